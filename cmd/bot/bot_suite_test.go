@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/anirbanmu/substitute-bot-go/pkg/reddit"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestBot(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "bot Suite")
+}
+
+// FakeCommentPoster fails each post with the next of errs (succeeding once they run out)
+type FakeCommentPoster struct {
+	errs  []error
+	posts int
+}
+
+func (p *FakeCommentPoster) PostComment(fullname string, bodyMarkdown string) (*reddit.Comment, error) {
+	p.posts++
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &reddit.Comment{Name: "t1_reply", ParentID: fullname, Body: bodyMarkdown}, nil
+}
+
+var _ = Describe("bot", func() {
+	Describe("replyPoster", func() {
+		var (
+			api     *FakeCommentPoster
+			poster  *replyPoster
+			waits   []time.Duration
+			replies []*reddit.Comment
+		)
+
+		BeforeEach(func() {
+			api = &FakeCommentPoster{}
+			poster = newReplyPoster(api)
+			waits = nil
+			poster.afterFunc = func(d time.Duration, f func()) {
+				waits = append(waits, d)
+				f()
+			}
+			replies = nil
+		})
+
+		post := func() {
+			poster.post("subreddit", "t1_parent", "body", func(reply *reddit.Comment) {
+				replies = append(replies, reply)
+			})
+		}
+
+		It("posts replies", func() {
+			post()
+			Expect(api.posts).To(Equal(1))
+			Expect(replies).To(HaveLen(1))
+			Expect(replies[0].ParentID).To(Equal("t1_parent"))
+		})
+
+		Context("when rate limited", func() {
+			It("retries after the wait Reddit asks for", func() {
+				api.errs = []error{&reddit.RateLimitedError{RetryAfter: 5 * time.Minute}}
+				post()
+				Expect(waits).To(Equal([]time.Duration{5 * time.Minute}))
+				Expect(api.posts).To(Equal(2))
+				Expect(replies).To(HaveLen(1))
+			})
+
+			It("finds the rate limit among several errors", func() {
+				api.errs = []error{reddit.ResponseErrors{&reddit.ResponseError{Code: "OTHER"}, &reddit.RateLimitedError{RetryAfter: time.Second}}}
+				post()
+				Expect(waits).To(Equal([]time.Duration{time.Second}))
+				Expect(replies).To(HaveLen(1))
+			})
+
+			It("waits defaultRateLimitWait when Reddit doesn't say how long", func() {
+				api.errs = []error{&reddit.RateLimitedError{}}
+				post()
+				Expect(waits).To(Equal([]time.Duration{defaultRateLimitWait}))
+			})
+
+			It("only retries once", func() {
+				api.errs = []error{&reddit.RateLimitedError{RetryAfter: time.Second}, &reddit.RateLimitedError{RetryAfter: time.Second}}
+				post()
+				Expect(api.posts).To(Equal(2))
+				Expect(replies).To(BeEmpty())
+			})
+
+			It("drops replies asked to wait longer than maxRateLimitWait", func() {
+				api.errs = []error{&reddit.RateLimitedError{RetryAfter: maxRateLimitWait + time.Minute}}
+				post()
+				Expect(waits).To(BeEmpty())
+				Expect(api.posts).To(Equal(1))
+				Expect(replies).To(BeEmpty())
+			})
+		})
+
+		Context("when banned from the subreddit", func() {
+			It("skips the subreddit from then on", func() {
+				Expect(poster.isBanned("subreddit")).To(BeFalse())
+
+				api.errs = []error{&reddit.BannedFromSubredditError{}}
+				post()
+				Expect(replies).To(BeEmpty())
+				Expect(poster.isBanned("SubReddit")).To(BeTrue())
+				Expect(poster.isBanned("other")).To(BeFalse())
+			})
+		})
+
+		Context("when posting fails otherwise", func() {
+			It("gives up on the reply", func() {
+				api.errs = []error{errors.New("some error")}
+				post()
+				Expect(waits).To(BeEmpty())
+				Expect(replies).To(BeEmpty())
+				Expect(poster.isBanned("subreddit")).To(BeFalse())
+			})
+		})
+	})
+})
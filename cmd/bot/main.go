@@ -1,12 +1,17 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/anirbanmu/substitute-bot-go/pkg/persistence"
 	"github.com/anirbanmu/substitute-bot-go/pkg/reddit"
@@ -16,12 +21,120 @@ import (
 	"github.com/ugorji/go/codec"
 )
 
+// Reddit rejects comments longer than this many characters
+const maxCommentLength = 10000
+
+const replyFooter = "\n\n^^This ^^was ^^posted ^^by ^^a ^^bot. ^^[Source](https://github.com/anirbanmu/substitute-bot-go)"
+
+// maxQuotedMatches caps how many matches are quoted in the summary replied to count only commands
+const maxQuotedMatches = 5
+
+// maxHints caps how many hints are replied to commands that changed nothing
+const maxHints = 3
+
+// defaultRateLimitWait is waited before retrying a reply Reddit rate limited without saying for how long
+const defaultRateLimitWait = time.Minute
+
+// maxRateLimitWait caps how long a rate limited reply waits to be retried. Replies asked to wait longer are dropped.
+const maxRateLimitWait = 15 * time.Minute
+
 type atomicCounter struct{ c uint64 }
 
 func (a *atomicCounter) incr()         { atomic.AddUint64(&a.c, 1) }
 func (a *atomicCounter) count() uint64 { return atomic.LoadUint64(&a.c) }
 
-func constructStoredReplyFromPosted(requester string, posted reddit.Comment) persistence.Reply {
+// errorCounters tallies the reasons comments were skipped or failed to produce a reply
+type errorCounters struct {
+	notCommand     atomicCounter
+	invalidCommand atomicCounter
+	invalidPattern atomicCounter
+	noChange       atomicCounter
+	limitExceeded  atomicCounter
+	other          atomicCounter
+}
+
+// count records err under its category & returns the category's description
+func (e *errorCounters) count(err error) string {
+	var parseErr *substitution.ParseError
+	var patternErr *substitution.InvalidPatternError
+	var tooManyStagesErr *substitution.TooManyStagesError
+	var scriptTooLongErr *substitution.ScriptTooLongError
+	var patternTooLongErr *substitution.PatternTooLongError
+	var programTooLargeErr *substitution.ProgramTooLargeError
+	var tooManyReplacementsErr *substitution.TooManyReplacementsError
+	var outputTooLongErr *substitution.OutputTooLongError
+	var timeoutErr *substitution.MatchTimeoutError
+
+	switch {
+	case errors.Is(err, substitution.ErrNotCommand):
+		e.notCommand.incr()
+		return "not a command"
+	case errors.Is(err, substitution.ErrNoChange):
+		e.noChange.incr()
+		return "no change"
+	case errors.As(err, &patternErr):
+		e.invalidPattern.incr()
+		return "invalid pattern"
+	case errors.As(err, &tooManyStagesErr), errors.As(err, &scriptTooLongErr), errors.As(err, &patternTooLongErr),
+		errors.As(err, &programTooLargeErr), errors.As(err, &tooManyReplacementsErr), errors.As(err, &outputTooLongErr),
+		errors.As(err, &timeoutErr):
+		e.limitExceeded.incr()
+		return "limit exceeded"
+	case errors.As(err, &parseErr):
+		e.invalidCommand.incr()
+		return "invalid command"
+	default:
+		e.other.incr()
+		return "other error"
+	}
+}
+
+func (e *errorCounters) String() string {
+	return fmt.Sprintf(
+		"not a command: %d, invalid command: %d, invalid pattern: %d, no change: %d, limit exceeded: %d, other: %d",
+		e.notCommand.count(),
+		e.invalidCommand.count(),
+		e.invalidPattern.count(),
+		e.noChange.count(),
+		e.limitExceeded.count(),
+		e.other.count(),
+	)
+}
+
+// describeModes lists the distinct modes used by the commands of a pipeline (e.g. "vim" or "vim, literal")
+func describeModes(pipeline substitution.Pipeline) string {
+	modes := []string{}
+	seen := map[substitution.Mode]bool{}
+	for _, cmd := range pipeline {
+		if !seen[cmd.Flags.Mode] {
+			seen[cmd.Flags.Mode] = true
+			modes = append(modes, cmd.Flags.Mode.String())
+		}
+	}
+
+	return strings.Join(modes, ", ")
+}
+
+// summarizeMatches describes the matches found by count only commands, quoting the first few
+func summarizeMatches(matches []substitution.Match) string {
+	noun := "matches"
+	if len(matches) == 1 {
+		noun = "match"
+	}
+
+	paragraphs := []string{fmt.Sprintf("found %d %s", len(matches), noun)}
+	for i, m := range matches {
+		if i == maxQuotedMatches {
+			paragraphs = append(paragraphs, fmt.Sprintf("...and %d more", len(matches)-maxQuotedMatches))
+			break
+		}
+		paragraphs = append(paragraphs, "> "+strings.ReplaceAll(m.Excerpt, "\n", "\n> "))
+	}
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func constructStoredReplyFromPosted(requester string, mode string, posted reddit.Comment) persistence.Reply {
 	return persistence.Reply{
 		Author:         posted.Author,
 		AuthorFullname: posted.Author,
@@ -33,11 +146,12 @@ func constructStoredReplyFromPosted(requester string, posted reddit.Comment) per
 		ParentID:       posted.ParentID,
 		Permalink:      posted.Permalink,
 		Requester:      requester,
+		Mode:           mode,
 	}
 }
 
 func createAPIAndStore(creds reddit.Credentials) (*reddit.API, *persistence.Store) {
-	api, err := reddit.InitAPI(creds, nil)
+	api, err := reddit.InitAPI(creds, reddit.OptionsFromEnv())
 	if err != nil {
 		log.Panicf("failed to initialize Reddit API: %s", err)
 	}
@@ -50,12 +164,160 @@ func createAPIAndStore(creds reddit.Credentials) (*reddit.API, *persistence.Stor
 	return api, store
 }
 
+// summarizeHints describes near misses of commands that changed nothing
+func summarizeHints(hints []substitution.Hint) string {
+	lines := []string{}
+	for i, h := range hints {
+		if i == maxHints {
+			break
+		}
+		lines = append(lines, "- "+h.String())
+	}
+
+	return "nothing matched\n\n" + strings.Join(lines, "\n")
+}
+
+// hintsAsWritten gathers the hints of a pipeline that changed nothing, showing each with the flags of the command as
+// the user wrote it rather than those the bot added (like m when markdown aware)
+func hintsAsWritten(written substitution.Pipeline, pipeline substitution.Pipeline, txt string, limits *substitution.Limits) []substitution.Hint {
+	hints := []substitution.Hint{}
+	for i := range pipeline {
+		for _, h := range pipeline[i].Hints(txt, limits) {
+			h.Command.Flags.Markdown = written[i].Flags.Markdown
+			hints = append(hints, h)
+		}
+	}
+
+	return hints
+}
+
+// parseSubreddits parses a comma separated list of subreddits (with or without r/) into a set of lower case names
+func parseSubreddits(list string) map[string]bool {
+	subreddits := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "r/")
+		if len(name) > 0 {
+			subreddits[name] = true
+		}
+	}
+
+	return subreddits
+}
+
+// parseScanModes parses a comma separated list of subreddit:mode pairs (e.g. "AskReddit:lenient") into a map of lower
+// case subreddit names to the substitution.ScanMode used for them
+func parseScanModes(list string) (map[string]substitution.ScanMode, error) {
+	modes := map[string]substitution.ScanMode{}
+	for _, pair := range strings.Split(list, ",") {
+		if len(strings.TrimSpace(pair)) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected subreddit:mode but got %q", pair)
+		}
+
+		mode, err := substitution.ParseScanMode(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		modes[strings.TrimPrefix(strings.ToLower(strings.TrimSpace(parts[0])), "r/")] = mode
+	}
+
+	return modes, nil
+}
+
+type commentPoster interface {
+	PostComment(fullname string, bodyMarkdown string) (*reddit.Comment, error)
+}
+
+// replyPoster posts the replies of substituteBot. A reply Reddit rate limits is retried once (in the background) after
+// the wait it asks for & subreddits the bot turns out to be banned from are remembered so that they can be skipped.
+type replyPoster struct {
+	api       commentPoster
+	afterFunc func(time.Duration, func()) // schedules retries
+	mutex     sync.Mutex
+	banned    map[string]bool // lower case names of subreddits the bot can't post in
+}
+
+func newReplyPoster(api commentPoster) *replyPoster {
+	return &replyPoster{
+		api:       api,
+		afterFunc: func(d time.Duration, f func()) { time.AfterFunc(d, f) },
+		banned:    map[string]bool{},
+	}
+}
+
+// isBanned reports whether posting in subreddit has failed because the bot is banned from it
+func (p *replyPoster) isBanned(subreddit string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.banned[strings.ToLower(subreddit)]
+}
+
+// post replies to the comment named parent (in subreddit) with body, calling posted with the reply once it's up. That
+// may be after post returns when the reply had to be retried.
+func (p *replyPoster) post(subreddit string, parent string, body string, posted func(*reddit.Comment)) {
+	p.attempt(subreddit, parent, body, posted, true)
+}
+
+func (p *replyPoster) attempt(subreddit string, parent string, body string, posted func(*reddit.Comment), retry bool) {
+	reply, err := p.api.PostComment(parent, body)
+	if err == nil {
+		posted(reply)
+		return
+	}
+
+	var rateLimitedErr *reddit.RateLimitedError
+	var bannedErr *reddit.BannedFromSubredditError
+	switch {
+	case retry && errors.As(err, &rateLimitedErr):
+		wait := rateLimitedErr.RetryAfter
+		if wait <= 0 {
+			wait = defaultRateLimitWait
+		}
+		if wait > maxRateLimitWait {
+			log.Printf("processing comment %s - rate limited for %s, too long to wait to retry the reply: %s", parent, wait, err)
+			return
+		}
+
+		log.Printf("processing comment %s - rate limited, retrying the reply in %s: %s", parent, wait, err)
+		p.afterFunc(wait, func() {
+			p.attempt(subreddit, parent, body, posted, false)
+		})
+	case errors.As(err, &bannedErr):
+		p.mutex.Lock()
+		p.banned[strings.ToLower(subreddit)] = true
+		p.mutex.Unlock()
+		log.Printf("processing comment %s - banned from r/%s, skipping it from now on: %s", parent, subreddit, err)
+	default:
+		log.Printf("processing comment %s - failed to post comment reply: %s", parent, err)
+	}
+}
+
 type substituteBot struct {
 	commentCounter atomicCounter
+	errorCounters  errorCounters
 	store          *persistence.Store
 	api            *reddit.API
+	poster         *replyPoster
 	botUsername    string
 	bot            grawReddit.Bot
+	markdownAware  bool
+	highlightStyle substitution.HighlightStyle
+	limits         substitution.Limits
+	hintSubreddits map[string]bool // subreddits where commands that changed nothing get hints
+	scanMode       substitution.ScanMode
+	scanModes      map[string]substitution.ScanMode // overrides of scanMode by subreddit
+}
+
+// scanModeFor returns where commands are recognized in comments of a subreddit
+func (r *substituteBot) scanModeFor(subreddit string) substitution.ScanMode {
+	if mode, ok := r.scanModes[strings.ToLower(subreddit)]; ok {
+		return mode
+	}
+	return r.scanMode
 }
 
 func (r *substituteBot) Comment(comment *grawReddit.Comment) error {
@@ -65,19 +327,32 @@ func (r *substituteBot) Comment(comment *grawReddit.Comment) error {
 		return nil
 	}
 
+	if r.poster.isBanned(comment.Subreddit) {
+		return nil
+	}
+
 	processed, err := r.store.AlreadyProcessedCommentID(comment.ID)
 	if err != nil || processed {
 		return nil
 	}
 	r.store.AddProcessedCommentID(comment.ID)
 
-	cmd, err := substitution.ParseSubstitutionCommand(comment.Body)
+	scanMode := r.scanModeFor(comment.Subreddit)
+	pipeline, line, err := substitution.FindPipeline(comment.Body, scanMode, nil)
 	if err != nil {
+		// Most comments aren't commands at all so only actual attempts are worth logging
+		if category := r.errorCounters.count(err); !errors.Is(err, substitution.ErrNotCommand) {
+			log.Printf("processing comment %s - %s parsing substitution.Pipeline (%s scan): %s", comment.Name, category, scanMode, err)
+		}
 		return nil
 	}
+	if line > 1 {
+		log.Printf("processing comment %s - found commands on line %d (%s scan)", comment.Name, line, scanMode)
+	}
 
-	if len(cmd.ReplaceWith) > 0 {
-		cmd.ReplaceWith = "**" + cmd.ReplaceWith + "**"
+	written := append(substitution.Pipeline{}, pipeline...)
+	for i := range pipeline {
+		pipeline[i].Flags.Markdown = pipeline[i].Flags.Markdown || r.markdownAware
 	}
 
 	parent, err := r.api.GetComment(comment.ParentID)
@@ -85,28 +360,59 @@ func (r *substituteBot) Comment(comment *grawReddit.Comment) error {
 		return nil
 	}
 
-	body, err := cmd.Run(parent.Body)
+	mode := describeModes(pipeline)
+
+	result, err := pipeline.ApplyWithLimits(parent.Body, &r.limits)
+	var hints []substitution.Hint
 	if err != nil {
-		log.Printf("processing comment %s - error trying to run substitution.Command{%s, %s}.Run(%s): %s", comment.Name, cmd.ToReplace, cmd.ReplaceWith, parent.Body, err)
-		return nil
+		category := r.errorCounters.count(err)
+		log.Printf("processing comment %s - %s trying to run substitution.Pipeline%+v.Apply(%s) in %s mode: %s", comment.Name, category, pipeline, parent.Body, mode, err)
+
+		// Commands that changed nothing are answered with hints in subreddits that opted into them
+		if !errors.Is(err, substitution.ErrNoChange) || !r.hintSubreddits[strings.ToLower(comment.Subreddit)] {
+			return nil
+		}
+		if hints = hintsAsWritten(written, pipeline, parent.Body, &r.limits); len(hints) == 0 {
+			return nil
+		}
+		result = &substitution.Result{}
 	}
 
+	// Count only & explained commands are answered with a summary of their matches after any changes
+	paragraphs := []string{}
+	if len(result.Changes) > 0 {
+		paragraphs = append(paragraphs, result.Highlight(r.highlightStyle))
+	}
+	if result.Counted {
+		paragraphs = append(paragraphs, summarizeMatches(result.Matches))
+	}
+	if len(result.Explanation) > 0 {
+		paragraphs = append(paragraphs, result.Explanation)
+	}
+	if len(hints) > 0 {
+		paragraphs = append(paragraphs, summarizeHints(hints))
+	}
+
+	body := strings.Join(paragraphs, "\n\n")
 	if len(body) == 0 {
-		log.Printf("processing comment %s - 0 length body for substitution.Command{%s, %s}.Run(%s)", comment.Name, cmd.ToReplace, cmd.ReplaceWith, parent.Body)
+		log.Printf("processing comment %s - 0 length body for substitution.Pipeline%+v.Apply(%s)", comment.Name, pipeline, parent.Body)
 		return nil
 	}
 
-	posted, err := r.api.PostComment(comment.Name, body+"\n\n^^This ^^was ^^posted ^^by ^^a ^^bot. ^^[Source](https://github.com/anirbanmu/substitute-bot-go)")
-	if err != nil {
-		log.Printf("processing comment %s - failed to post comment reply: %s", comment.Name, err)
+	// Highlighting & summaries add to the output so the reply as a whole has to be checked again
+	if err := r.limits.CheckOutputLength(body); err != nil {
+		r.errorCounters.count(err)
+		log.Printf("processing comment %s - highlighted reply too long to post: %s", comment.Name, err)
 		return nil
 	}
 
-	log.Printf("processing comment %s - posted reply (%s)", comment.Name, posted.Name)
+	r.poster.post(comment.Subreddit, comment.Name, body+replyFooter, func(posted *reddit.Comment) {
+		log.Printf("processing comment %s - posted reply (%s) in %s mode", comment.Name, posted.Name, mode)
 
-	if _, err := r.store.AddReplyWithTrim(constructStoredReplyFromPosted(comment.Author, *posted), 50); err != nil {
-		log.Printf("processing comment %s - failed to store comment reply: %s", comment.Name, err)
-	}
+		if _, err := r.store.AddReplyWithTrim(constructStoredReplyFromPosted(comment.Author, mode, *posted), 50); err != nil {
+			log.Printf("processing comment %s - failed to store comment reply: %s", comment.Name, err)
+		}
+	})
 
 	return nil
 }
@@ -137,20 +443,72 @@ func main() {
 
 	cfg := graw.Config{SubredditComments: []string{"all"}}
 
+	// Substitutions leave code & links in the parent comment alone unless explicitly disabled
+	markdownAware := true
+	if value, ok := os.LookupEnv("SUBSTITUTE_BOT_MARKDOWN_AWARE"); ok {
+		markdownAware, err = strconv.ParseBool(value)
+		if err != nil {
+			log.Panicf("SUBSTITUTE_BOT_MARKDOWN_AWARE must be a boolean: %s", err)
+		}
+	}
+
+	if value, ok := os.LookupEnv("SUBSTITUTE_BOT_DEFAULT_ENGINE"); ok {
+		substitution.DefaultEngine, err = substitution.LookupEngine(value)
+		if err != nil {
+			log.Panicf("SUBSTITUTE_BOT_DEFAULT_ENGINE is invalid: %s", err)
+		}
+	}
+
+	highlightStyle := substitution.HighlightBold
+	if value, ok := os.LookupEnv("SUBSTITUTE_BOT_HIGHLIGHT_STYLE"); ok {
+		highlightStyle, err = substitution.ParseHighlightStyle(value)
+		if err != nil {
+			log.Panicf("SUBSTITUTE_BOT_HIGHLIGHT_STYLE is invalid: %s", err)
+		}
+	}
+
+	hintSubreddits := parseSubreddits(os.Getenv("SUBSTITUTE_BOT_HINT_SUBREDDITS"))
+
+	scanMode := substitution.ScanStrict
+	if value, ok := os.LookupEnv("SUBSTITUTE_BOT_SCAN_MODE"); ok {
+		scanMode, err = substitution.ParseScanMode(value)
+		if err != nil {
+			log.Panicf("SUBSTITUTE_BOT_SCAN_MODE is invalid: %s", err)
+		}
+	}
+
+	scanModes, err := parseScanModes(os.Getenv("SUBSTITUTE_BOT_SUBREDDIT_SCAN_MODES"))
+	if err != nil {
+		log.Panicf("SUBSTITUTE_BOT_SUBREDDIT_SCAN_MODES is invalid: %s", err)
+	}
+
 	api, store := createAPIAndStore(creds)
-	handler := &substituteBot{store: store, api: api, botUsername: creds.Username, bot: bot}
+	handler := &substituteBot{
+		store:          store,
+		api:            api,
+		poster:         newReplyPoster(api),
+		botUsername:    creds.Username,
+		bot:            bot,
+		markdownAware:  markdownAware,
+		highlightStyle: highlightStyle,
+		limits:         substitution.DefaultLimits,
+		hintSubreddits: hintSubreddits,
+		scanMode:       scanMode,
+		scanModes:      scanModes,
+	}
+	handler.limits.MaxOutputLength = maxCommentLength - utf8.RuneCountInString(replyFooter)
 	_, wait, err := graw.Run(handler, bot, cfg)
 	if err != nil {
 		log.Panicf("Failed to start graw run: %s", err)
 	}
 
-	// Heartbeat logger (don't care about waiting for this goroutine to exit)
+	// Heartbeat logger of comment counts & the Reddit API budget, which is stopped & waited for once graw is done
 	wg := sync.WaitGroup{}
 	defer wg.Wait()
 
 	done := make(chan bool, 1)
+	wg.Add(1)
 	go func() {
-		wg.Add(1)
 		defer wg.Done()
 
 		for {
@@ -158,7 +516,10 @@ func main() {
 			case <-done:
 				return
 			case <-time.After(60 * time.Second):
-				log.Printf("processed %d comments in total.", handler.commentCounter.count())
+				log.Printf("processed %d comments in total (%s).", handler.commentCounter.count(), &handler.errorCounters)
+				if budget, ok := handler.api.RateLimit(); ok {
+					log.Printf("reddit API budget: %.0f requests left (%d used), resetting in %s.", budget.Remaining, budget.Used, time.Until(budget.Reset).Round(time.Second))
+				}
 			}
 		}
 	}()
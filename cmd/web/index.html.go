@@ -21,7 +21,23 @@ const indexTemplate = `<!DOCTYPE html>
     <div class="content">
         <p>Substitute-Bot is a combination of a bot for Reddit that provides VIM style search + replace functionality for comments and also a front end web component (what you're reading right now). Written in Go. Redis is utilized very lightly for keeping a running list of posted comments.</p>
 
-        <p>Syntax is VIM-like - <code>s/SEARCH/REPLACE</code> or <code>s#SEARCH#REPLACE</code>. Post a reply to another comment with this syntax and this bot will process your request & post your requested replacement.</p>
+        <p>Syntax is VIM-like - <code>s/SEARCH/REPLACE</code> or <code>s#SEARCH#REPLACE</code>. Like sed, any other punctuation but a period works as a delimiter too (<code>s|SEARCH|REPLACE|</code>) & a delimiter escaped with a backslash is taken literally (<code>s/and\/or/or/</code>). Post a reply to another comment with this syntax and this bot will process your request & post your requested replacement.</p>
+
+        <p>Flags can follow a closing delimiter - <code>s/SEARCH/REPLACE/FLAGS</code>. Only the first match is replaced unless <code>g</code> is given, <code>i</code> ignores case & a number <code>N</code> replaces only the Nth match (or the Nth onwards when combined with <code>g</code>). The <code>p</code> flag keeps the case of whatever is replaced (<code>s/cat/dog/gip</code> turns <code>Cat CAT</code> into <code>Dog DOG</code>). The <code>w</code> flag only replaces whole words, in any language (<code>s/café/tea/w</code>). The <code>n</code> flag counts every match of the pattern & quotes them instead of replacing anything (<code>s/literally//n</code>). The <code>?</code> flag (or starting the command with <code>explain</code>) breaks the pattern down step by step & lists what it matches instead of replacing anything (<code>explain s/\d\+ \(cats\|dogs\)//g</code>).</p>
+
+        <p>Several commands can be chained, one per line or separated by <code>;</code> after a closing delimiter - <code>s/a/b/;s/c/d/g</code>. They are applied in order.</p>
+
+        <p>Commands can be limited to certain lines of the comment with an address - a line number (<code>3s/a/b/</code>), a range (<code>2,5s/a/b/</code>), the last line (<code>$s/a/b/</code>), every line (<code>%s/a/b/</code>) or lines matching a pattern (<code>/^&gt;/s/a/b/</code>). Flags then apply to each addressed line separately.</p>
+
+        <p>Like sed, <code>y/SOURCE/DEST/</code> swaps each character of <code>SOURCE</code> for the one at the same position of <code>DEST</code> (<code>y/aeiou/eioua/</code>).</p>
+
+        <p>A few of sed's line commands work too, so a comment can hold a small sed script: <code>d</code> deletes the addressed lines (<code>2,4d</code>), <code>a\TEXT</code> & <code>i\TEXT</code> add a line after or before each addressed line & <code>c\TEXT</code> replaces them (<code>/^P.S./c\P.S. never mind</code>). Line commands always need an address (<code>%</code> for every line) & only run on their own in subreddits that opt into them - elsewhere the comment needs an <code>s</code> or <code>y</code> command too. The text runs to the end of the line, so those commands have to end it. Scripts are capped at 2000 characters & run as a single request.</p>
+
+        <p>Patterns use VIM syntax (<code>\(group\)</code>, <code>\&lt;word\&gt;</code>, <code>\{2,3}</code>, very magic <code>\v</code> & very nomagic <code>\V</code>). Backreferences & lookarounds aren't supported there, but the <code>b</code> flag switches to a backtracking engine with Go's syntax that supports them (<code>s/(?&lt;=foo)bar/baz/b</code>, <code>s/(\w+) \1/$1/gb</code>). The <code>l</code> flag matches plain text instead (<code>s/C++/Go/l</code>).</p>
+
+        <p>Code & links in the comment being replied to are left alone. The <code>c</code> flag opts into substituting inside code & the <code>u</code> flag inside link URLs.</p>
+
+        <p>Replacements use VIM syntax - <code>\1</code> to <code>\9</code> insert groups, <code>&amp;</code> inserts the whole match (<code>\&amp;</code> for a literal one) & <code>\u</code> <code>\l</code> <code>\U</code> <code>\L</code> <code>\E</code> change case. The <code>R</code> flag switches both the pattern & replacement to Go's syntax (<code>$1</code>, <code>${name}</code>) instead.</p>
 
         <div class="pure-g center-text" id="link-icon-row">
             <div class="pure-u-1-3">
@@ -49,6 +65,7 @@ const indexTemplate = `<!DOCTYPE html>
                                 <span>Requested by</span>
                                 <a href="https://www.reddit.com/u/{{ .Requester }}">/u/{{ .Requester }}</a>
                             </p>
+                            {{ if .Mode }}<p>Mode: {{ .Mode }}</p>{{ end }}
                             <a href="https://www.reddit.com{{ .Permalink }}">Comment link</a>
                         </div>
                     </div>
@@ -37,6 +37,7 @@ func (r *SuccessfulReplyFetcher) FetchReply(count int64) ([]persistence.Reply, e
 			ParentID:       "t1_f5uyrdf",
 			Permalink:      "r/subreddit/comments/de31f1/title/f5uyrhf",
 			Requester:      "requester-username-user",
+			Mode:           "literal",
 		},
 	}
 	return replies, nil
@@ -90,6 +91,7 @@ var _ = Describe("web", func() {
 					body, err := ioutil.ReadAll(resp.Body)
 					Expect(err).NotTo(HaveOccurred())
 					Expect(string(body)).To(ContainSubstring("October 18, 2019"))
+					Expect(string(body)).To(ContainSubstring("Mode: literal"))
 				})
 			})
 		})
@@ -4,6 +4,7 @@ go 1.15
 
 require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dlclark/regexp2 v1.7.0
 	github.com/fsnotify/fsnotify v1.5.1 // indirect
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/kr/pretty v0.1.0 // indirect
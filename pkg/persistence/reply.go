@@ -20,6 +20,7 @@ type Reply struct {
 	ParentID       string `json:"parent_id"`
 	Permalink      string `json:"permalink"`
 	Requester      string `json:"requester"`
+	Mode           string `json:"mode"`
 }
 
 // RenderMarkdown renders & sanitizes the stored markdown into a HTML string
@@ -0,0 +1,177 @@
+package reddit
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResponseError is an error Reddit listed in the json.errors of a response. The more specific errors below each wrap
+// one.
+type ResponseError struct {
+	Code    string // e.g. RATELIMIT
+	Message string
+	Field   string // the request field the error concerns (if any)
+}
+
+func (e *ResponseError) Error() string {
+	if len(e.Field) == 0 {
+		return fmt.Sprintf("Reddit API error %s: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("Reddit API error %s (%s): %s", e.Code, e.Field, e.Message)
+}
+
+// RateLimitedError is returned when Reddit refuses an action (like commenting) done too often. RetryAfter is how long
+// it asked to wait before trying again, or 0 when it didn't say.
+type RateLimitedError struct {
+	ResponseError
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Unwrap() error {
+	return &e.ResponseError
+}
+
+// ThreadLockedError is returned when replying in a thread that has been locked
+type ThreadLockedError struct {
+	ResponseError
+}
+
+func (e *ThreadLockedError) Unwrap() error {
+	return &e.ResponseError
+}
+
+// DeletedCommentError is returned when replying to a comment that has been deleted
+type DeletedCommentError struct {
+	ResponseError
+}
+
+func (e *DeletedCommentError) Unwrap() error {
+	return &e.ResponseError
+}
+
+// TooLongError is returned when a field (like a comment's text) is longer than allowed. Max is the limit given by
+// Reddit, or 0 when it didn't say.
+type TooLongError struct {
+	ResponseError
+	Max int
+}
+
+func (e *TooLongError) Unwrap() error {
+	return &e.ResponseError
+}
+
+// BannedFromSubredditError is returned when posting to a subreddit that doesn't allow the account to post there
+type BannedFromSubredditError struct {
+	ResponseError
+}
+
+func (e *BannedFromSubredditError) Unwrap() error {
+	return &e.ResponseError
+}
+
+// ResponseErrors is returned when Reddit lists several errors in the json.errors of a response. errors.As finds any of
+// them, so checking for a particular error type works the same as when only one was listed.
+type ResponseErrors []error
+
+func (e ResponseErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// As reports whether any of the errors can be assigned to target (like errors.As), assigning the first that can
+func (e ResponseErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfterRe matches the wait Reddit asks for in its RATELIMIT messages (you are doing that too much. try again in 5
+// minutes.)
+var retryAfterRe = regexp.MustCompile(`(\d+) (millisecond|second|minute|hour)s?`)
+
+// tooLongRe matches the limit Reddit gives in its TOO_LONG messages (this is too long (max: 10000))
+var tooLongRe = regexp.MustCompile(`max: (\d+)`)
+
+var retryAfterUnits = map[string]time.Duration{
+	"millisecond": time.Millisecond,
+	"second":      time.Second,
+	"minute":      time.Minute,
+	"hour":        time.Hour,
+}
+
+func parseRetryAfterMessage(message string) time.Duration {
+	match := retryAfterRe.FindStringSubmatch(strings.ToLower(message))
+	if match == nil {
+		return 0
+	}
+
+	n, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0
+	}
+	return time.Duration(n) * retryAfterUnits[match[2]]
+}
+
+func parseTooLongMessage(message string) int {
+	match := tooLongRe.FindStringSubmatch(message)
+	if match == nil {
+		return 0
+	}
+
+	max, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0
+	}
+	return max
+}
+
+// newResponseError converts an entry of json.errors ([code, message, field]) into the matching error type
+func newResponseError(entry []string) error {
+	var responseErr ResponseError
+	fields := []*string{&responseErr.Code, &responseErr.Message, &responseErr.Field}
+	for i := 0; i < len(entry) && i < len(fields); i++ {
+		*fields[i] = entry[i]
+	}
+
+	switch responseErr.Code {
+	case "RATELIMIT":
+		return &RateLimitedError{responseErr, parseRetryAfterMessage(responseErr.Message)}
+	case "THREAD_LOCKED":
+		return &ThreadLockedError{responseErr}
+	case "DELETED_COMMENT":
+		return &DeletedCommentError{responseErr}
+	case "TOO_LONG":
+		return &TooLongError{responseErr, parseTooLongMessage(responseErr.Message)}
+	case "SUBREDDIT_NOTALLOWED", "BANNED_FROM_SUBREDDIT":
+		return &BannedFromSubredditError{responseErr}
+	default:
+		return &responseErr
+	}
+}
+
+// parseResponseErrors converts the json.errors of a response into an error, or nil when there are none. Several are
+// returned together as ResponseErrors.
+func parseResponseErrors(entries [][]string) error {
+	switch len(entries) {
+	case 0:
+		return nil
+	case 1:
+		return newResponseError(entries[0])
+	}
+
+	errs := make(ResponseErrors, 0, len(entries))
+	for _, entry := range entries {
+		errs = append(errs, newResponseError(entry))
+	}
+	return errs
+}
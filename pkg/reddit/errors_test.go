@@ -0,0 +1,82 @@
+package reddit
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("parseResponseErrors", func() {
+	It("returns nil when there are no errors", func() {
+		Expect(parseResponseErrors(nil)).To(BeNil())
+		Expect(parseResponseErrors([][]string{})).To(BeNil())
+	})
+
+	DescribeTable("converts known codes into typed errors",
+		func(entry []string, expected error) {
+			Expect(parseResponseErrors([][]string{entry})).To(Equal(expected))
+		},
+		Entry("rate limited in minutes",
+			[]string{"RATELIMIT", "you are doing that too much. try again in 5 minutes.", "ratelimit"},
+			&RateLimitedError{ResponseError{"RATELIMIT", "you are doing that too much. try again in 5 minutes.", "ratelimit"}, 5 * time.Minute}),
+		Entry("rate limited in seconds",
+			[]string{"RATELIMIT", "Looks like you've been doing that a lot. Take a break for 40 seconds before trying again.", "ratelimit"},
+			&RateLimitedError{ResponseError{"RATELIMIT", "Looks like you've been doing that a lot. Take a break for 40 seconds before trying again.", "ratelimit"}, 40 * time.Second}),
+		Entry("rate limited without a duration",
+			[]string{"RATELIMIT", "you are doing that too much.", "ratelimit"},
+			&RateLimitedError{ResponseError{"RATELIMIT", "you are doing that too much.", "ratelimit"}, 0}),
+		Entry("thread locked",
+			[]string{"THREAD_LOCKED", "Comments are locked.", "parent"},
+			&ThreadLockedError{ResponseError{"THREAD_LOCKED", "Comments are locked.", "parent"}}),
+		Entry("deleted comment",
+			[]string{"DELETED_COMMENT", "that comment has been deleted", "parent"},
+			&DeletedCommentError{ResponseError{"DELETED_COMMENT", "that comment has been deleted", "parent"}}),
+		Entry("too long",
+			[]string{"TOO_LONG", "this is too long (max: 10000)", "text"},
+			&TooLongError{ResponseError{"TOO_LONG", "this is too long (max: 10000)", "text"}, 10000}),
+		Entry("not allowed in subreddit",
+			[]string{"SUBREDDIT_NOTALLOWED", "you aren't allowed to post there.", "parent"},
+			&BannedFromSubredditError{ResponseError{"SUBREDDIT_NOTALLOWED", "you aren't allowed to post there.", "parent"}}),
+		Entry("unknown code",
+			[]string{"NO_TEXT", "we need something here", "text"},
+			&ResponseError{"NO_TEXT", "we need something here", "text"}),
+		Entry("missing field",
+			[]string{"USER_REQUIRED"},
+			&ResponseError{Code: "USER_REQUIRED"}),
+	)
+
+	It("keeps every one of several errors", func() {
+		err := parseResponseErrors([][]string{
+			{"THREAD_LOCKED", "Comments are locked.", "parent"},
+			{"RATELIMIT", "you are doing that too much. try again in 5 minutes.", "ratelimit"},
+			{"NO_TEXT", "we need something here", "text"},
+		})
+		Expect(err).To(HaveLen(3))
+		Expect(err.Error()).To(Equal("Reddit API error THREAD_LOCKED (parent): Comments are locked.; " +
+			"Reddit API error RATELIMIT (ratelimit): you are doing that too much. try again in 5 minutes.; " +
+			"Reddit API error NO_TEXT (text): we need something here"))
+
+		var lockedErr *ThreadLockedError
+		Expect(errors.As(err, &lockedErr)).To(BeTrue())
+		Expect(lockedErr.Field).To(Equal("parent"))
+
+		var rateLimitedErr *RateLimitedError
+		Expect(errors.As(err, &rateLimitedErr)).To(BeTrue())
+		Expect(rateLimitedErr.RetryAfter).To(Equal(5 * time.Minute))
+
+		var deletedErr *DeletedCommentError
+		Expect(errors.As(err, &deletedErr)).To(BeFalse())
+	})
+
+	It("lets every typed error be matched as a ResponseError", func() {
+		err := parseResponseErrors([][]string{{"TOO_LONG", "this is too long (max: 10000)", "text"}})
+
+		var responseErr *ResponseError
+		Expect(errors.As(err, &responseErr)).To(BeTrue())
+		Expect(responseErr.Code).To(Equal("TOO_LONG"))
+		Expect(err.Error()).To(Equal("Reddit API error TOO_LONG (text): this is too long (max: 10000)"))
+	})
+})
@@ -0,0 +1,132 @@
+package reddit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRateLimitedAttempts caps how many times a request is sent while Reddit rejects it for exceeding the rate limit
+const maxRateLimitedAttempts = 3
+
+// defaultRateLimitWindow is how long requests are held back after a 429 that didn't say when the window resets (or
+// when to retry)
+const defaultRateLimitWindow = time.Minute
+
+// RateLimit is the request budget Reddit grants the API, as last reported by its X-Ratelimit-* response headers (less
+// any requests sent since)
+type RateLimit struct {
+	Remaining float64   // requests left in the current window
+	Used      int       // requests made in the current window
+	Reset     time.Time // when the current window ends
+}
+
+// rateLimiter keeps the budget shared by every caller of an API, holding callers back once it's spent until the
+// window resets
+type rateLimiter struct {
+	mutex  sync.Mutex
+	known  bool
+	budget RateLimit
+}
+
+// parseRateLimit reads a budget from Reddit's response headers, reporting whether they were all present & valid
+func parseRateLimit(header http.Header) (RateLimit, bool) {
+	remaining, err := strconv.ParseFloat(header.Get("X-Ratelimit-Remaining"), 64)
+	if err != nil {
+		return RateLimit{}, false
+	}
+
+	used, err := strconv.Atoi(header.Get("X-Ratelimit-Used"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+
+	reset, err := strconv.ParseFloat(header.Get("X-Ratelimit-Reset"), 64)
+	if err != nil {
+		return RateLimit{}, false
+	}
+
+	return RateLimit{remaining, used, time.Now().Add(time.Duration(reset * float64(time.Second)))}, true
+}
+
+// wait blocks until the budget allows another request & takes that request out of it, or until ctx is done. Nothing
+// is held back while the budget is unknown (e.g. before the first response or once a window has ended).
+func (r *rateLimiter) wait(ctx context.Context) error {
+	for {
+		r.mutex.Lock()
+		if !r.known || !time.Now().Before(r.budget.Reset) {
+			r.known = false
+			r.mutex.Unlock()
+			return nil
+		}
+
+		if r.budget.Remaining >= 1 {
+			r.budget.Remaining--
+			r.budget.Used++
+			r.mutex.Unlock()
+			return nil
+		}
+
+		reset := r.budget.Reset
+		r.mutex.Unlock()
+		if err := sleep(ctx, time.Until(reset)); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep blocks for d or until ctx is done, returning ctx's error in the latter case
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// update replaces the budget with the one reported by a response (if it reported one)
+func (r *rateLimiter) update(header http.Header) {
+	budget, ok := parseRateLimit(header)
+	if !ok {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.known = true
+	r.budget = budget
+}
+
+// exhaust spends the budget after Reddit rejected a request for exceeding it
+func (r *rateLimiter) exhaust(header http.Header) {
+	budget, ok := parseRateLimit(header)
+	if !ok {
+		window, ok := parseRetryAfter(header)
+		if !ok {
+			window = defaultRateLimitWindow
+		}
+		budget = RateLimit{Reset: time.Now().Add(window)}
+	}
+	budget.Remaining = 0
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.known = true
+	r.budget = budget
+}
+
+// snapshot returns the current budget & whether it's known
+func (r *rateLimiter) snapshot() (RateLimit, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.budget, r.known && time.Now().Before(r.budget.Reset)
+}
@@ -0,0 +1,102 @@
+package reddit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("rateLimiter", func() {
+	var limiter *rateLimiter
+
+	BeforeEach(func() {
+		limiter = &rateLimiter{}
+	})
+
+	Describe("wait", func() {
+		Context("when the budget is unknown", func() {
+			It("does not block", func() {
+				start := time.Now()
+				limiter.wait(context.Background())
+				Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond))
+			})
+		})
+
+		Context("when concurrent callers share a budget", func() {
+			It("only lets the budget through before the window resets", func() {
+				limiter.known = true
+				limiter.budget = RateLimit{Remaining: 2, Reset: time.Now().Add(300 * time.Millisecond)}
+
+				start := time.Now()
+				waited := make(chan time.Duration, 3)
+				wg := sync.WaitGroup{}
+				for i := 0; i < 3; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						limiter.wait(context.Background())
+						waited <- time.Since(start)
+					}()
+				}
+				wg.Wait()
+				close(waited)
+
+				late := 0
+				for d := range waited {
+					if d >= 250*time.Millisecond {
+						late++
+					}
+				}
+				Expect(late).To(Equal(1))
+			})
+		})
+
+		Context("when the context is done before the window resets", func() {
+			It("stops waiting & returns its error", func() {
+				limiter.known = true
+				limiter.budget = RateLimit{Remaining: 0, Reset: time.Now().Add(time.Minute)}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+
+				start := time.Now()
+				Expect(limiter.wait(ctx)).To(Equal(context.DeadlineExceeded))
+				Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			})
+		})
+	})
+
+	Describe("update", func() {
+		Context("when headers are missing or invalid", func() {
+			It("leaves the budget alone", func() {
+				limiter.update(http.Header{"X-Ratelimit-Remaining": []string{"lots"}, "X-Ratelimit-Used": []string{"1"}, "X-Ratelimit-Reset": []string{"1"}})
+				_, known := limiter.snapshot()
+				Expect(known).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("exhaust", func() {
+		Context("when no reset is given", func() {
+			It("holds requests back for the default window", func() {
+				limiter.exhaust(http.Header{})
+				budget, known := limiter.snapshot()
+				Expect(known).To(BeTrue())
+				Expect(budget.Remaining).To(BeZero())
+				Expect(budget.Reset).To(BeTemporally("~", time.Now().Add(defaultRateLimitWindow), time.Second))
+			})
+		})
+
+		Context("when only Retry-After is given", func() {
+			It("holds requests back for as long as it asks", func() {
+				limiter.exhaust(http.Header{"Retry-After": []string{"5"}})
+				budget, _ := limiter.snapshot()
+				Expect(budget.Reset).To(BeTemporally("~", time.Now().Add(5*time.Second), time.Second))
+			})
+		})
+	})
+})
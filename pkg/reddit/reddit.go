@@ -1,6 +1,7 @@
 package reddit
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,7 +18,6 @@ import (
 )
 
 const (
-	baseRedditURL   = "https://www.reddit.com"
 	apiBaseURL      = "https://www.reddit.com/api"
 	oauthAPIBaseURL = "https://oauth.reddit.com/api"
 )
@@ -31,14 +31,49 @@ type Credentials struct {
 	UserAgent    string
 }
 
+// Options configures an API. Blank fields take their defaults.
+type Options struct {
+	Client      *http.Client // a client with a 10 second timeout when nil
+	AuthURL     string       // base URL of the auth API (https://www.reddit.com/api)
+	APIURL      string       // base URL of the OAuth API (https://oauth.reddit.com/api)
+	RetryPolicy *RetryPolicy // DefaultRetryPolicy when nil
+}
+
+// OptionsFromEnv reads the base URLs of Options from the SUBSTITUTE_BOT_REDDIT_AUTH_URL &
+// SUBSTITUTE_BOT_REDDIT_API_URL environment variables (e.g. to point the API at a local stand-in for Reddit)
+func OptionsFromEnv() *Options {
+	return &Options{
+		AuthURL: os.Getenv("SUBSTITUTE_BOT_REDDIT_AUTH_URL"),
+		APIURL:  os.Getenv("SUBSTITUTE_BOT_REDDIT_API_URL"),
+	}
+}
+
+// validateBaseURL checks that a configured base URL is an absolute http(s) URL
+func validateBaseURL(name string, baseURL string) error {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %s", name, baseURL, err)
+	}
+
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+		return fmt.Errorf("invalid %s %q: expected an absolute http(s) URL", name, baseURL)
+	}
+
+	return nil
+}
+
 // API provides the abstraction to the reddit API
 type API struct {
-	creds     Credentials
-	Client    *http.Client
-	token     string
-	grantTime time.Time
-	mutex     sync.RWMutex
-	Decoder   *codec.Decoder
+	creds       Credentials
+	authURL     string
+	apiURL      string
+	Client      *http.Client
+	token       string
+	grantTime   time.Time
+	mutex       sync.RWMutex
+	rateLimiter rateLimiter
+	Decoder     *codec.Decoder
+	RetryPolicy *RetryPolicy // DefaultRetryPolicy when nil
 }
 
 type basicAuth struct {
@@ -66,13 +101,13 @@ func buildURL(baseURL string, path string, query *url.Values) (*url.URL, error)
 	return resolved, nil
 }
 
-func postURLEncodedForm(client *http.Client, fullURL string, args *url.Values, headers *map[string]string, auth *basicAuth) ([]byte, error) {
+func newURLEncodedFormRequest(ctx context.Context, fullURL string, args *url.Values, headers *map[string]string, auth *basicAuth) (*http.Request, error) {
 	body := ""
 	if args != nil {
 		body = args.Encode()
 	}
 
-	req, err := http.NewRequest("POST", fullURL, strings.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", fullURL, strings.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
@@ -88,6 +123,15 @@ func postURLEncodedForm(client *http.Client, fullURL string, args *url.Values, h
 		req.SetBasicAuth(auth.user, auth.pass)
 	}
 
+	return req, nil
+}
+
+func postURLEncodedForm(ctx context.Context, client *http.Client, fullURL string, args *url.Values, headers *map[string]string, auth *basicAuth) ([]byte, error) {
+	req, err := newURLEncodedFormRequest(ctx, fullURL, args, headers, auth)
+	if err != nil {
+		return nil, err
+	}
+
 	res, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -95,68 +139,141 @@ func postURLEncodedForm(client *http.Client, fullURL string, args *url.Values, h
 
 	defer res.Body.Close()
 
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("%s returned %d", fullURL, res.StatusCode)
-	}
-
 	resBody, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
 	}
 
+	if res.StatusCode != 200 {
+		return nil, newAPIError(req, res.StatusCode, resBody)
+	}
+
 	return resBody, nil
 }
 
-func (api *API) postURLEncodedForm(path string, query *url.Values) ([]byte, error) {
-	if err := api.reAuth(); err != nil {
-		return nil, err
+func (api *API) authBaseURL() string {
+	if len(api.authURL) == 0 {
+		return apiBaseURL
 	}
+	return api.authURL
+}
 
-	apiURL, err := buildURL(oauthAPIBaseURL, path, nil)
-	if err != nil {
-		return nil, err
+func (api *API) apiBaseURL() string {
+	if len(api.apiURL) == 0 {
+		return oauthAPIBaseURL
 	}
-
-	headers := map[string]string{"User-Agent": api.creds.UserAgent, "Authorization": "bearer " + api.authToken()}
-
-	return postURLEncodedForm(api.Client, apiURL.String(), query, &headers, nil)
+	return api.apiURL
 }
 
-func (api *API) getJSON(path string, query *url.Values) ([]byte, error) {
-	if err := api.reAuth(); err != nil {
-		return nil, err
+func (api *API) retryPolicy() *RetryPolicy {
+	if api.RetryPolicy == nil {
+		return &DefaultRetryPolicy
 	}
+	return api.RetryPolicy
+}
 
-	apiURL, err := buildURL(oauthAPIBaseURL, path, query)
-	if err != nil {
-		return nil, err
+// send sends the request built by newRequest once the rate limit allows it, keeping track of the budget reported by
+// the response. A request rejected for exceeding the rate limit is built & sent again after the window resets, while
+// one that failed transiently is sent again as the RetryPolicy allows (only when idempotent by default). Responses
+// other than 200 are returned as an *APIError. Waiting stops as soon as ctx is done.
+func (api *API) send(ctx context.Context, idempotent bool, newRequest func() (*http.Request, error)) ([]byte, error) {
+	policy := api.retryPolicy()
+	for attempt := 1; ; attempt++ {
+		if err := api.rateLimiter.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := api.Client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || !policy.allows(attempt, idempotent) {
+				return nil, err
+			}
+
+			backoff := policy.backoff(attempt)
+			log.Printf("reddit.API - %s failed (%s), retrying in %s", req.URL.Path, err, backoff)
+			if err := sleep(ctx, backoff); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode == http.StatusTooManyRequests && attempt < maxRateLimitedAttempts {
+			api.rateLimiter.exhaust(res.Header)
+			log.Printf("reddit.API - rate limited by %s, waiting for the window to reset", req.URL.Path)
+			continue
+		}
+		api.rateLimiter.update(res.Header)
+
+		if retryableStatuses[res.StatusCode] && policy.allows(attempt, idempotent) {
+			backoff := policy.retryAfter(res.Header, attempt)
+			log.Printf("reddit.API - %s returned %d, retrying in %s", req.URL.Path, res.StatusCode, backoff)
+			if err := sleep(ctx, backoff); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if res.StatusCode != 200 {
+			return nil, newAPIError(req, res.StatusCode, body)
+		}
+
+		return body, nil
 	}
+}
 
-	req, err := http.NewRequest("GET", apiURL.String(), nil)
-	if err != nil {
+func (api *API) postURLEncodedForm(ctx context.Context, path string, query *url.Values) ([]byte, error) {
+	if err := api.reAuth(ctx); err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("User-Agent", api.creds.UserAgent)
-	req.Header.Add("Authorization", "bearer "+api.authToken())
-
-	res, err := api.Client.Do(req)
+	apiURL, err := buildURL(api.apiBaseURL(), path, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	defer res.Body.Close()
+	return api.send(ctx, false, func() (*http.Request, error) {
+		headers := map[string]string{"User-Agent": api.creds.UserAgent, "Authorization": "bearer " + api.authToken()}
+		return newURLEncodedFormRequest(ctx, apiURL.String(), query, &headers, nil)
+	})
+}
 
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("Reddit %s API returned %d", path, res.StatusCode)
+func (api *API) getJSON(ctx context.Context, path string, query *url.Values) ([]byte, error) {
+	if err := api.reAuth(ctx); err != nil {
+		return nil, err
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	apiURL, err := buildURL(api.apiBaseURL(), path, query)
 	if err != nil {
 		return nil, err
 	}
 
-	return body, nil
+	return api.send(ctx, true, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", apiURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Add("User-Agent", api.creds.UserAgent)
+		req.Header.Add("Authorization", "bearer "+api.authToken())
+		return req, nil
+	})
+}
+
+// RateLimit returns the request budget left in the current rate limit window, reporting whether it's known (Reddit
+// only reports it in responses & it's forgotten once the window ends)
+func (api *API) RateLimit() (RateLimit, bool) {
+	return api.rateLimiter.snapshot()
 }
 
 // IsFullnameComment allows external checking of if a fullname represents a comment (beginning with t1_)
@@ -166,11 +283,16 @@ func IsFullnameComment(fullname string) bool {
 
 // GetComment retrieves a comment by its fullname (t1_*) from the reddit API
 func (api *API) GetComment(fullname string) (*Comment, error) {
+	return api.GetCommentContext(context.Background(), fullname)
+}
+
+// GetCommentContext is like GetComment, giving up once ctx is done
+func (api *API) GetCommentContext(ctx context.Context, fullname string) (*Comment, error) {
 	if !IsFullnameComment(fullname) {
 		return nil, errors.New("full name given was not a comment")
 	}
 
-	res, err := api.getJSON("/info", &url.Values{"id": {fullname}, "raw_json": {"1"}})
+	res, err := api.getJSON(ctx, "/info", &url.Values{"id": {fullname}, "raw_json": {"1"}})
 	if err != nil {
 		return nil, err
 	}
@@ -200,8 +322,15 @@ func (api *API) GetComment(fullname string) (*Comment, error) {
 	return nil, errors.New("Could not retrieve comment")
 }
 
-// PostComment posts a reply to the comment, referenced by fullname, with content of bodyMarkdown
+// PostComment posts a reply to the comment, referenced by fullname, with content of bodyMarkdown. Errors Reddit lists in
+// its response are returned as a *ResponseError, or one of the more specific types wrapping it (like
+// *RateLimitedError), gathered into ResponseErrors when there are several.
 func (api *API) PostComment(fullname string, bodyMarkdown string) (*Comment, error) {
+	return api.PostCommentContext(context.Background(), fullname, bodyMarkdown)
+}
+
+// PostCommentContext is like PostComment, giving up once ctx is done
+func (api *API) PostCommentContext(ctx context.Context, fullname string, bodyMarkdown string) (*Comment, error) {
 	if len(fullname) == 0 {
 		return nil, errors.New("fullname is blank")
 	}
@@ -217,9 +346,8 @@ func (api *API) PostComment(fullname string, bodyMarkdown string) (*Comment, err
 		"text":     {bodyMarkdown},
 	}
 
-	res, err := api.postURLEncodedForm("/comment", &body)
+	res, err := api.postURLEncodedForm(ctx, "/comment", &body)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 
@@ -244,12 +372,8 @@ func (api *API) PostComment(fullname string, bodyMarkdown string) (*Comment, err
 		return nil, err
 	}
 
-	if len(parsed.JSON.Errors) > 0 {
-		errorStrings := make([]string, 0, len(parsed.JSON.Errors))
-		for i := 0; i < len(parsed.JSON.Errors); i++ {
-			errorStrings = append(errorStrings, "["+strings.Join(parsed.JSON.Errors[i], ", ")+"]")
-		}
-		return nil, errors.New("API errors: " + strings.Join(errorStrings, ", "))
+	if err := parseResponseErrors(parsed.JSON.Errors); err != nil {
+		return nil, err
 	}
 
 	if parsed.JSON.Data.Things != nil && len(parsed.JSON.Data.Things) == 1 && parsed.JSON.Data.Things[0].Kind == "t1" {
@@ -270,7 +394,7 @@ func (api *API) authValid() bool {
 	return time.Since(api.grantTime) < 40*time.Minute
 }
 
-func (api *API) reAuth() error {
+func (api *API) reAuth(ctx context.Context) error {
 	api.mutex.RLock()
 	valid := api.authValid()
 	api.mutex.RUnlock()
@@ -287,7 +411,7 @@ func (api *API) reAuth() error {
 	}
 
 	log.Printf("reddit.API - initiating re auth")
-	token, err := auth(api.creds, api.Client)
+	token, err := auth(ctx, api.creds, api.Client, api.authBaseURL())
 	if err != nil {
 		log.Printf("reddit.API - failed to re auth: %s", err)
 		return err
@@ -300,8 +424,14 @@ func (api *API) reAuth() error {
 	return nil
 }
 
-// InitAPIFromEnv initializes (& auths) a reddit API client by reading credentials from the environment variables
+// InitAPIFromEnv initializes (& auths) a reddit API client by reading credentials (& any base URLs, see
+// OptionsFromEnv) from the environment variables
 func InitAPIFromEnv(client *http.Client) (*API, error) {
+	return InitAPIFromEnvContext(context.Background(), client)
+}
+
+// InitAPIFromEnvContext is like InitAPIFromEnv, giving up on auth once ctx is done
+func InitAPIFromEnvContext(ctx context.Context, client *http.Client) (*API, error) {
 	creds := Credentials{
 		os.Getenv("SUBSTITUTE_BOT_USERNAME"),
 		os.Getenv("SUBSTITUTE_BOT_PASSWORD"),
@@ -330,55 +460,60 @@ func InitAPIFromEnv(client *http.Client) (*API, error) {
 		return nil, errors.New("environment variable SUBSTITUTE_BOT_USER_AGENT is required")
 	}
 
-	return InitAPI(creds, client)
+	options := OptionsFromEnv()
+	options.Client = client
+	return InitAPIContext(ctx, creds, options)
 }
 
-// InitAPI initializes (& auths) a reddit API client using the credentials provided
-func InitAPI(creds Credentials, client *http.Client) (*API, error) {
-	if client == nil {
-		client = &http.Client{Timeout: time.Second * 10}
+// InitAPI initializes (& auths) a reddit API client using the credentials & options (defaults when nil) provided
+func InitAPI(creds Credentials, options *Options) (*API, error) {
+	return InitAPIContext(context.Background(), creds, options)
+}
+
+// InitAPIContext is like InitAPI, giving up on auth once ctx is done
+func InitAPIContext(ctx context.Context, creds Credentials, options *Options) (*API, error) {
+	if options == nil {
+		options = &Options{}
 	}
 
-	token, err := auth(creds, client)
-	if err != nil {
-		return nil, err
+	if len(options.AuthURL) > 0 {
+		if err := validateBaseURL("auth URL", options.AuthURL); err != nil {
+			return nil, err
+		}
 	}
 
-	return &API{
-		creds:     creds,
-		Client:    client,
-		token:     token,
-		grantTime: time.Now(),
-		Decoder:   codec.NewDecoderBytes(nil, &codec.JsonHandle{}),
-	}, nil
-}
+	if len(options.APIURL) > 0 {
+		if err := validateBaseURL("API URL", options.APIURL); err != nil {
+			return nil, err
+		}
+	}
 
-func buildAuthRequest(creds Credentials) (*http.Request, error) {
-	reqBody := url.Values{
-		"grant_type": {"password"},
-		"username":   {creds.Username},
-		"password":   {creds.Password},
+	client := options.Client
+	if client == nil {
+		client = &http.Client{Timeout: time.Second * 10}
 	}
 
-	apiURL, err := buildURL(apiBaseURL, "/v1/access_token", nil)
-	if err != nil {
-		return nil, err
+	api := &API{
+		creds:       creds,
+		authURL:     options.AuthURL,
+		apiURL:      options.APIURL,
+		Client:      client,
+		Decoder:     codec.NewDecoderBytes(nil, &codec.JsonHandle{}),
+		RetryPolicy: options.RetryPolicy,
 	}
 
-	req, err := http.NewRequest("POST", apiURL.String(), strings.NewReader(reqBody.Encode()))
+	token, err := auth(ctx, creds, client, api.authBaseURL())
 	if err != nil {
 		return nil, err
 	}
 
-	req.SetBasicAuth(creds.ClientID, creds.ClientSecret)
-	req.Header.Add("User-Agent", creds.UserAgent)
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-
-	return req, nil
+	api.token = token
+	api.grantTime = time.Now()
+	return api, nil
 }
 
-func auth(creds Credentials, client *http.Client) (string, error) {
-	apiURL, err := buildURL(apiBaseURL, "/v1/access_token", nil)
+func auth(ctx context.Context, creds Credentials, client *http.Client, authURL string) (string, error) {
+	apiURL, err := buildURL(authURL, "/v1/access_token", nil)
 	if err != nil {
 		return "", err
 	}
@@ -393,7 +528,7 @@ func auth(creds Credentials, client *http.Client) (string, error) {
 
 	auth := basicAuth{creds.ClientID, creds.ClientSecret}
 
-	res, err := postURLEncodedForm(client, apiURL.String(), &args, &headers, &auth)
+	res, err := postURLEncodedForm(ctx, client, apiURL.String(), &args, &headers, &auth)
 	if err != nil {
 		return "", err
 	}
@@ -1,11 +1,14 @@
 package reddit
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -14,9 +17,25 @@ import (
 	"github.com/ugorji/go/codec"
 )
 
+// closedServerURL returns the URL of an address that's no longer listening
+func closedServerURL() string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	listener.Close()
+	return "http://" + listener.Addr().String()
+}
+
+// resetConnection drops the connection of a request without responding
+func resetConnection(w http.ResponseWriter, r *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	Expect(err).NotTo(HaveOccurred())
+	conn.Close()
+}
+
 var _ = Describe("Reddit", func() {
 	var server *ghttp.Server
 	var client *http.Client
+	var options *Options
 	var api *API
 
 	creds := Credentials{
@@ -30,27 +49,19 @@ var _ = Describe("Reddit", func() {
 
 	BeforeEach(func() {
 		server = ghttp.NewServer()
-
-		serverURL, err := url.Parse(server.URL())
-		Expect(err).ToNot(HaveOccurred())
-
-		dialMock := func(network, addr string) (net.Conn, error) {
-			return net.Dial(network, serverURL.Host)
-		}
-
-		client = &http.Client{
-			Transport: &http.Transport{
-				Dial:    dialMock,
-				DialTLS: dialMock,
-			},
-		}
+		client = &http.Client{}
+		options = &Options{Client: client, AuthURL: server.URL() + "/api", APIURL: server.URL() + "/api"}
 
 		api = &API{
 			creds:     creds,
+			authURL:   options.AuthURL,
+			apiURL:    options.APIURL,
 			Client:    client,
 			token:     token,
 			grantTime: time.Now(),
 			Decoder:   codec.NewDecoderBytes(nil, &codec.JsonHandle{}),
+			// Retries are covered by their own specs
+			RetryPolicy: &RetryPolicy{MaxAttempts: 1},
 		}
 	})
 
@@ -114,6 +125,31 @@ var _ = Describe("Reddit", func() {
 			Expect(err.Error()).To(ContainSubstring("SUBSTITUTE_BOT_USER_AGENT"))
 			Expect(createdAPI).To(BeNil())
 		})
+
+		It("uses the base URLs from SUBSTITUTE_BOT_REDDIT_AUTH_URL & SUBSTITUTE_BOT_REDDIT_API_URL", func() {
+			os.Setenv("SUBSTITUTE_BOT_REDDIT_AUTH_URL", server.URL()+"/auth")
+			os.Setenv("SUBSTITUTE_BOT_REDDIT_API_URL", server.URL()+"/oauth")
+			defer os.Unsetenv("SUBSTITUTE_BOT_REDDIT_AUTH_URL")
+			defer os.Unsetenv("SUBSTITUTE_BOT_REDDIT_API_URL")
+
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/auth/v1/access_token"),
+					ghttp.RespondWith(http.StatusOK, "{\"access_token\":\""+token+"\"}"),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/oauth/info"),
+					ghttp.RespondWith(http.StatusOK, `{"kind":"Listing","data":{"children":[]}}`),
+				),
+			)
+
+			createdAPI, err := InitAPIFromEnv(client)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(createdAPI.token).To(Equal(token))
+
+			createdAPI.GetComment("t1_h7kxui2")
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
 	})
 
 	Describe("InitAPI", func() {
@@ -138,7 +174,7 @@ var _ = Describe("Reddit", func() {
 				)
 				server.AppendHandlers(ghttp.CombineHandlers(handlers...))
 
-				createdAPI, err := InitAPI(creds, client)
+				createdAPI, err := InitAPI(creds, options)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(createdAPI).NotTo(BeNil())
 				Expect(createdAPI.token).To(Equal(token))
@@ -153,7 +189,7 @@ var _ = Describe("Reddit", func() {
 				)
 				server.AppendHandlers(ghttp.CombineHandlers(handlers...))
 
-				createdAPI, err := InitAPI(creds, client)
+				createdAPI, err := InitAPI(creds, options)
 				Expect(err).To(HaveOccurred())
 				Expect(createdAPI).To(BeNil())
 			})
@@ -167,7 +203,7 @@ var _ = Describe("Reddit", func() {
 				)
 				server.AppendHandlers(ghttp.CombineHandlers(handlers...))
 
-				createdAPI, err := InitAPI(creds, client)
+				createdAPI, err := InitAPI(creds, options)
 				Expect(err).To(HaveOccurred())
 				Expect(createdAPI).To(BeNil())
 			})
@@ -181,28 +217,31 @@ var _ = Describe("Reddit", func() {
 				)
 				server.AppendHandlers(ghttp.CombineHandlers(handlers...))
 
-				createdAPI, err := InitAPI(creds, client)
+				createdAPI, err := InitAPI(creds, options)
 				Expect(err).To(HaveOccurred())
 				Expect(createdAPI).To(BeNil())
 			})
 		})
 
+		Context("when a base URL is invalid", func() {
+			It("returns error & no API without sending anything", func() {
+				options.APIURL = "oauth.reddit.com/api"
+
+				createdAPI, err := InitAPI(creds, options)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("API URL"))
+				Expect(createdAPI).To(BeNil())
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
 		Context("when there is a network error", func() {
 			BeforeEach(func() {
-				dialMock := func(network, addr string) (net.Conn, error) {
-					return net.Dial(network, "")
-				}
-
-				client = &http.Client{
-					Transport: &http.Transport{
-						Dial:    dialMock,
-						DialTLS: dialMock,
-					},
-				}
+				options.AuthURL = closedServerURL() + "/api"
 			})
 
 			It("returns error & no API", func() {
-				createdAPI, err := InitAPI(creds, client)
+				createdAPI, err := InitAPI(creds, options)
 				Expect(err).To(HaveOccurred())
 				Expect(createdAPI).To(BeNil())
 			})
@@ -286,33 +325,29 @@ var _ = Describe("Reddit", func() {
 		})
 
 		Context("when API returns non 200 status code", func() {
-			It("returns error & no Comment", func() {
+			It("returns an APIError & no Comment", func() {
 				handlers := append(
 					verificationHandlers,
-					ghttp.RespondWith(http.StatusInternalServerError, ""),
+					ghttp.RespondWith(http.StatusInternalServerError, `{"message": "Internal Server Error", "error": 500}`),
 				)
 				server.AppendHandlers(ghttp.CombineHandlers(handlers...))
 
 				c, err := api.GetComment(comment.Name)
-				Expect(err).To(HaveOccurred())
 				Expect(c).To(BeNil())
+
+				var apiErr *APIError
+				Expect(errors.As(err, &apiErr)).To(BeTrue())
+				Expect(*apiErr).To(Equal(APIError{
+					StatusCode: http.StatusInternalServerError,
+					Endpoint:   "/api/info",
+					Body:       `{"message": "Internal Server Error", "error": 500}`,
+				}))
 			})
 		})
 
 		Context("when there is a network error", func() {
 			BeforeEach(func() {
-				dialMock := func(network, addr string) (net.Conn, error) {
-					return net.Dial(network, "")
-				}
-
-				client = &http.Client{
-					Transport: &http.Transport{
-						Dial:    dialMock,
-						DialTLS: dialMock,
-					},
-				}
-
-				api.Client = client
+				api.apiURL = closedServerURL() + "/api"
 			})
 
 			It("returns error & no Comment", func() {
@@ -402,18 +437,7 @@ var _ = Describe("Reddit", func() {
 
 		Context("when there is a network error", func() {
 			BeforeEach(func() {
-				dialMock := func(network, addr string) (net.Conn, error) {
-					return net.Dial(network, "")
-				}
-
-				client = &http.Client{
-					Transport: &http.Transport{
-						Dial:    dialMock,
-						DialTLS: dialMock,
-					},
-				}
-
-				api.Client = client
+				api.apiURL = closedServerURL() + "/api"
 			})
 
 			It("returns error & no Comment", func() {
@@ -432,9 +456,22 @@ var _ = Describe("Reddit", func() {
 				server.AppendHandlers(ghttp.CombineHandlers(handlers...))
 
 				c, err := api.PostComment(ParentID, bodyMd)
-				Expect(err).To(HaveOccurred())
+				Expect(err).To(Equal(&ResponseError{"NO_TEXT", "we need something here", "text"}))
 				Expect(c).To(BeNil())
 			})
+
+			It("returns a typed error for known errors", func() {
+				handlers := append(
+					verificationHandlers,
+					ghttp.RespondWith(http.StatusOK, `{"json":{"errors":[["RATELIMIT","you are doing that too much. try again in 6 minutes.","ratelimit"]],"data":{"things":[]}}}`),
+				)
+				server.AppendHandlers(ghttp.CombineHandlers(handlers...))
+
+				_, err := api.PostComment(ParentID, bodyMd)
+				var rateLimitedErr *RateLimitedError
+				Expect(errors.As(err, &rateLimitedErr)).To(BeTrue())
+				Expect(rateLimitedErr.RetryAfter).To(Equal(6 * time.Minute))
+			})
 		})
 
 		Context("when no parent id is given", func() {
@@ -454,6 +491,239 @@ var _ = Describe("Reddit", func() {
 		})
 	})
 
+	Describe("rate limiting", func() {
+		commentInfoJSON := `{"kind":"Listing","data":{"modhash":null,"dist":1,"children":[{"kind":"t1","data":` + string(commentJSON) + `}],"after":null,"before":null}}`
+		rateLimitHeaders := func(remaining string, used string, reset string) http.Header {
+			return http.Header{
+				"X-Ratelimit-Remaining": []string{remaining},
+				"X-Ratelimit-Used":      []string{used},
+				"X-Ratelimit-Reset":     []string{reset},
+			}
+		}
+
+		Context("when responses report the budget", func() {
+			It("keeps track of it", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusOK, commentInfoJSON, rateLimitHeaders("41.0", "559", "120")))
+
+				_, known := api.RateLimit()
+				Expect(known).To(BeFalse())
+
+				_, err := api.GetComment(comment.Name)
+				Expect(err).NotTo(HaveOccurred())
+
+				budget, known := api.RateLimit()
+				Expect(known).To(BeTrue())
+				Expect(budget.Remaining).To(Equal(41.0))
+				Expect(budget.Used).To(Equal(559))
+				Expect(budget.Reset).To(BeTemporally("~", time.Now().Add(120*time.Second), time.Second))
+			})
+		})
+
+		Context("when the budget is spent", func() {
+			It("waits for the window to reset before sending", func() {
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusOK, commentInfoJSON, rateLimitHeaders("0", "600", "0.3")),
+					ghttp.RespondWith(http.StatusOK, commentInfoJSON),
+				)
+
+				_, err := api.GetComment(comment.Name)
+				Expect(err).NotTo(HaveOccurred())
+
+				start := time.Now()
+				_, err = api.GetComment(comment.Name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(time.Since(start)).To(BeNumerically(">=", 250*time.Millisecond))
+			})
+		})
+
+		Context("when Reddit rejects a request for exceeding the rate limit", func() {
+			It("sends it again once the window resets", func() {
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusTooManyRequests, "", rateLimitHeaders("0", "600", "0.2")),
+					ghttp.RespondWith(http.StatusOK, commentInfoJSON),
+				)
+
+				c, err := api.GetComment(comment.Name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(*c).To(Equal(comment))
+				Expect(server.ReceivedRequests()).To(HaveLen(2))
+			})
+
+			It("gives up after a few attempts", func() {
+				for i := 0; i < maxRateLimitedAttempts; i++ {
+					server.AppendHandlers(ghttp.RespondWith(http.StatusTooManyRequests, "", rateLimitHeaders("0", "600", "0")))
+				}
+
+				_, err := api.GetComment(comment.Name)
+				Expect(err).To(HaveOccurred())
+				Expect(server.ReceivedRequests()).To(HaveLen(maxRateLimitedAttempts))
+			})
+		})
+	})
+
+	Describe("retries", func() {
+		commentInfoJSON := `{"kind":"Listing","data":{"modhash":null,"dist":1,"children":[{"kind":"t1","data":` + string(commentJSON) + `}],"after":null,"before":null}}`
+
+		BeforeEach(func() {
+			api.RetryPolicy = &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+		})
+
+		Context("when Reddit fails transiently", func() {
+			It("sends idempotent requests again", func() {
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+					ghttp.RespondWith(http.StatusBadGateway, ""),
+					ghttp.RespondWith(http.StatusOK, commentInfoJSON),
+				)
+
+				c, err := api.GetComment(comment.Name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(*c).To(Equal(comment))
+				Expect(server.ReceivedRequests()).To(HaveLen(3))
+			})
+
+			It("gives up after MaxAttempts", func() {
+				for i := 0; i < 3; i++ {
+					server.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, ""))
+				}
+
+				_, err := api.GetComment(comment.Name)
+				var apiErr *APIError
+				Expect(errors.As(err, &apiErr)).To(BeTrue())
+				Expect(apiErr.StatusCode).To(Equal(http.StatusInternalServerError))
+				Expect(server.ReceivedRequests()).To(HaveLen(3))
+			})
+
+			It("does not send non idempotent requests again by default", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusServiceUnavailable, ""))
+
+				_, err := api.PostComment(comment.ParentID, "body")
+				Expect(err).To(HaveOccurred())
+				Expect(server.ReceivedRequests()).To(HaveLen(1))
+			})
+
+			It("sends non idempotent requests again when the policy allows", func() {
+				api.RetryPolicy.RetryNonIdempotent = true
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+					ghttp.RespondWith(http.StatusOK, `{"json": {"errors": [], "data": {"things": [{"kind": "t1", "data": `+string(commentJSON)+`}]}}}`),
+				)
+
+				_, err := api.PostComment(comment.ParentID, "body")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(server.ReceivedRequests()).To(HaveLen(2))
+			})
+
+			It("waits as long as Retry-After asks", func() {
+				api.RetryPolicy.MaxBackoff = 2 * time.Second
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusServiceUnavailable, "", http.Header{"Retry-After": []string{"1"}}),
+					ghttp.RespondWith(http.StatusOK, commentInfoJSON),
+				)
+
+				start := time.Now()
+				_, err := api.GetComment(comment.Name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+			})
+
+			It("waits no longer than MaxBackoff whatever Retry-After asks", func() {
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusServiceUnavailable, "", http.Header{"Retry-After": []string{"3600"}}),
+					ghttp.RespondWith(http.StatusOK, commentInfoJSON),
+				)
+
+				start := time.Now()
+				_, err := api.GetComment(comment.Name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			})
+		})
+
+		Context("when Reddit fails permanently", func() {
+			It("does not send the request again", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusForbidden, strings.Repeat("x", 300)))
+
+				_, err := api.GetComment(comment.Name)
+				var apiErr *APIError
+				Expect(errors.As(err, &apiErr)).To(BeTrue())
+				Expect(apiErr.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(apiErr.Body).To(Equal(strings.Repeat("x", maxErrorBodyLength) + "..."))
+				Expect(server.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+
+		Context("when there is a network error", func() {
+			It("sends idempotent requests again", func() {
+				for i := 0; i < 3; i++ {
+					server.AppendHandlers(resetConnection)
+				}
+
+				_, err := api.GetComment(comment.Name)
+				Expect(err).To(HaveOccurred())
+				Expect(server.ReceivedRequests()).To(HaveLen(3))
+			})
+		})
+	})
+
+	Describe("contexts", func() {
+		Context("when the context is cancelled", func() {
+			It("does not send the request", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				c, err := api.GetCommentContext(ctx, comment.Name)
+				Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+				Expect(c).To(BeNil())
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+
+			It("stops waiting to retry", func() {
+				api.RetryPolicy = &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute, MaxBackoff: time.Minute}
+				server.AppendHandlers(ghttp.RespondWith(http.StatusServiceUnavailable, ""))
+
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+
+				start := time.Now()
+				_, err := api.GetCommentContext(ctx, comment.Name)
+				Expect(err).To(Equal(context.DeadlineExceeded))
+				Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+				Expect(server.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+
+		Context("when Reddit responds slower than the deadline", func() {
+			It("gives up on the request", func() {
+				server.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+					time.Sleep(500 * time.Millisecond)
+				})
+
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+
+				_, err := api.PostCommentContext(ctx, "t1_h7kxui2", "body")
+				Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+			})
+		})
+
+		Context("when re-authing", func() {
+			It("gives up once the context is done", func() {
+				api.grantTime = time.Now().Add(-time.Hour)
+				server.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+					time.Sleep(500 * time.Millisecond)
+				})
+
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+
+				_, err := api.GetCommentContext(ctx, comment.Name)
+				Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+				Expect(api.authToken()).To(Equal(token))
+			})
+		})
+	})
+
 	Describe("reAuth", func() {
 		verificationHandlers := []http.HandlerFunc{
 			ghttp.VerifyRequest("POST", "/api/v1/access_token"),
@@ -471,7 +741,7 @@ var _ = Describe("Reddit", func() {
 		Context("when renewal time has not elapsed", func() {
 			It("does not try to re-auth", func() {
 				originalToken := api.token
-				Expect(api.reAuth()).To(BeNil())
+				Expect(api.reAuth(context.Background())).To(BeNil())
 				Expect(originalToken).To(Equal(api.token))
 			})
 		})
@@ -491,7 +761,7 @@ var _ = Describe("Reddit", func() {
 
 					originalToken := api.token
 					originalgrantTime := api.grantTime
-					Expect(api.reAuth()).To(HaveOccurred())
+					Expect(api.reAuth(context.Background())).To(HaveOccurred())
 					Expect(api.token).To(Equal(originalToken))
 					Expect(api.grantTime).To(Equal(originalgrantTime))
 				})
@@ -507,7 +777,7 @@ var _ = Describe("Reddit", func() {
 					server.AppendHandlers(ghttp.CombineHandlers(handlers...))
 
 					originalgrantTime := api.grantTime
-					Expect(api.reAuth()).NotTo(HaveOccurred())
+					Expect(api.reAuth(context.Background())).NotTo(HaveOccurred())
 					Expect(api.token).To(Equal(newToken))
 					Expect(api.grantTime).NotTo(Equal(originalgrantTime))
 				})
@@ -0,0 +1,113 @@
+package reddit
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxErrorBodyLength caps how much of a response body an APIError keeps
+const maxErrorBodyLength = 200
+
+// APIError is returned when Reddit responds to a request with a status other than 200
+type APIError struct {
+	StatusCode int
+	Endpoint   string // path of the request (e.g. /api/info)
+	Body       string // the start of the response body
+}
+
+func (e *APIError) Error() string {
+	if len(e.Body) == 0 {
+		return fmt.Sprintf("Reddit %s API returned %d", e.Endpoint, e.StatusCode)
+	}
+	return fmt.Sprintf("Reddit %s API returned %d: %s", e.Endpoint, e.StatusCode, e.Body)
+}
+
+func newAPIError(req *http.Request, status int, body []byte) *APIError {
+	if len(body) > maxErrorBodyLength {
+		body = append(body[:maxErrorBodyLength:maxErrorBodyLength], "..."...)
+	}
+	return &APIError{status, req.URL.Path, string(body)}
+}
+
+// RetryPolicy determines how requests that failed transiently (a connection error or a 500, 502, 503 or 504) are
+// retried. Requests Reddit rejects for exceeding the rate limit are always sent again once the window resets.
+type RetryPolicy struct {
+	MaxAttempts        int           // attempts per request, including the first
+	InitialBackoff     time.Duration // wait before the second attempt, doubling for each one after
+	MaxBackoff         time.Duration // also caps how long a Retry-After may hold a retry back
+	Jitter             float64       // fraction of each backoff that's randomly skipped (0 to 1)
+	RetryNonIdempotent bool          // also retry requests that may have taken effect, like posting a comment
+}
+
+// DefaultRetryPolicy is used by an API without a RetryPolicy of its own
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Jitter:         0.5,
+}
+
+// retryableStatuses are the statuses of responses to requests that may succeed when sent again
+var retryableStatuses = map[int]bool{
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// allows reports whether a request that failed on the given (1-indexed) attempt may be sent again
+func (p *RetryPolicy) allows(attempt int, idempotent bool) bool {
+	return attempt < p.MaxAttempts && (idempotent || p.RetryNonIdempotent)
+}
+
+// backoff returns how long to wait after the given (1-indexed) attempt failed
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+
+	return backoff - time.Duration(p.Jitter*rand.Float64()*float64(backoff))
+}
+
+// retryAfter returns how long to wait after the given (1-indexed) attempt failed with a response carrying header,
+// honouring its Retry-After up to MaxBackoff
+func (p *RetryPolicy) retryAfter(header http.Header, attempt int) time.Duration {
+	wait, ok := parseRetryAfter(header)
+	if !ok {
+		return p.backoff(attempt)
+	}
+	if wait > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+
+	return wait
+}
+
+// parseRetryAfter reads how long a response asked for requests to be held back (in seconds or until a date),
+// reporting whether it did
+func parseRetryAfter(header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if len(value) == 0 {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
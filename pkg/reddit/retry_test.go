@@ -0,0 +1,78 @@
+package reddit
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryPolicy", func() {
+	Describe("backoff", func() {
+		It("doubles with each attempt up to MaxBackoff", func() {
+			policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 3 * time.Second}
+			Expect(policy.backoff(1)).To(Equal(time.Second))
+			Expect(policy.backoff(2)).To(Equal(2 * time.Second))
+			Expect(policy.backoff(3)).To(Equal(3 * time.Second))
+			Expect(policy.backoff(4)).To(Equal(3 * time.Second))
+		})
+
+		It("skips up to the Jitter fraction of it", func() {
+			policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: time.Second, Jitter: 0.5}
+			for i := 0; i < 20; i++ {
+				Expect(policy.backoff(1)).To(BeNumerically("~", 750*time.Millisecond, 250*time.Millisecond))
+			}
+		})
+	})
+
+	Describe("retryAfter", func() {
+		It("waits as long as Retry-After asks up to MaxBackoff", func() {
+			policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: time.Minute}
+			Expect(policy.retryAfter(http.Header{"Retry-After": []string{"30"}}, 1)).To(Equal(30 * time.Second))
+			Expect(policy.retryAfter(http.Header{"Retry-After": []string{"3600"}}, 1)).To(Equal(time.Minute))
+		})
+
+		It("backs off as usual without Retry-After", func() {
+			policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: time.Minute}
+			Expect(policy.retryAfter(http.Header{}, 2)).To(Equal(2 * time.Second))
+		})
+	})
+
+	Describe("allows", func() {
+		It("allows idempotent requests until MaxAttempts", func() {
+			policy := RetryPolicy{MaxAttempts: 2}
+			Expect(policy.allows(1, true)).To(BeTrue())
+			Expect(policy.allows(2, true)).To(BeFalse())
+			Expect(policy.allows(1, false)).To(BeFalse())
+		})
+
+		It("allows non idempotent requests when RetryNonIdempotent is set", func() {
+			policy := RetryPolicy{MaxAttempts: 2, RetryNonIdempotent: true}
+			Expect(policy.allows(1, false)).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("parseRetryAfter", func() {
+	It("reads seconds", func() {
+		wait, ok := parseRetryAfter(http.Header{"Retry-After": []string{"120"}})
+		Expect(ok).To(BeTrue())
+		Expect(wait).To(Equal(2 * time.Minute))
+	})
+
+	It("reads dates", func() {
+		date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+		wait, ok := parseRetryAfter(http.Header{"Retry-After": []string{date}})
+		Expect(ok).To(BeTrue())
+		Expect(wait).To(BeNumerically("~", time.Minute, 2*time.Second))
+	})
+
+	It("reports missing or invalid values", func() {
+		_, ok := parseRetryAfter(http.Header{})
+		Expect(ok).To(BeFalse())
+
+		_, ok = parseRetryAfter(http.Header{"Retry-After": []string{"soon"}})
+		Expect(ok).To(BeFalse())
+	})
+})
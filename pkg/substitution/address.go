@@ -0,0 +1,183 @@
+package substitution
+
+import "strings"
+
+// LineAddressKind is the way a LineAddress picks out lines
+type LineAddressKind int
+
+const (
+	// AddressNone means no address was given
+	AddressNone LineAddressKind = iota
+	// AddressLine picks out a line by number (3)
+	AddressLine
+	// AddressLast picks out the last line ($)
+	AddressLast
+	// AddressPattern picks out lines matching a pattern (/pattern/)
+	AddressPattern
+)
+
+// LineAddress is one end of an Address. Pattern is written in the syntax of the Command's Flags.Mode.
+type LineAddress struct {
+	Kind    LineAddressKind
+	Line    int
+	Pattern string
+}
+
+// Address selects the lines of a body that a Command applies to, like the addresses of sed (3s, 2,5s, $s, %s &
+// /pattern/s). The zero Address applies a Command to the body as a whole.
+type Address struct {
+	Start LineAddress
+	End   LineAddress // AddressNone unless a range was given
+}
+
+// IsZero reports whether no address was given
+func (a Address) IsZero() bool {
+	return a == Address{}
+}
+
+// splitLines returns the [start, end) byte offsets of each line of txt, excluding line breaks. A line break ending
+// txt doesn't begin another line.
+func splitLines(txt string) [][]int {
+	lines := [][]int{}
+	for start := 0; start < len(txt) || len(lines) == 0; {
+		end := strings.IndexByte(txt[start:], '\n')
+		if end < 0 {
+			lines = append(lines, []int{start, len(txt)})
+			break
+		}
+
+		lines = append(lines, []int{start, start + end})
+		start += end + 1
+	}
+
+	return lines
+}
+
+// compiledLineAddress is a LineAddress ready to be matched against lines
+type compiledLineAddress struct {
+	LineAddress
+	matcher *matcher
+}
+
+func compileLineAddress(l LineAddress, mode Mode, limits *Limits) (compiledLineAddress, error) {
+	compiled := compiledLineAddress{LineAddress: l}
+	if l.Kind != AddressPattern {
+		return compiled, nil
+	}
+
+	matcher, err := compilePattern(l.Pattern, Flags{Mode: mode}, limits)
+	if err != nil {
+		return compiled, err
+	}
+	compiled.matcher = matcher
+
+	return compiled, nil
+}
+
+func (l *compiledLineAddress) matches(n int, line string, total int) (bool, error) {
+	switch l.Kind {
+	case AddressLine:
+		return n == l.Line, nil
+	case AddressLast:
+		return n == total, nil
+	case AddressPattern:
+		return l.matcher.matchString(line)
+	default:
+		return false, nil
+	}
+}
+
+// endsRangeAt reports whether a range ending in this address stops at line n. A line number at or before the line
+// that began the range ends it straight away while a pattern is only looked for from the following line.
+func (l *compiledLineAddress) endsRangeAt(n int, line string, total int, began bool) (bool, error) {
+	switch {
+	case l.Kind == AddressLine:
+		return n >= l.Line, nil
+	case began && l.Kind == AddressPattern:
+		return false, nil
+	default:
+		return l.matches(n, line, total)
+	}
+}
+
+// lines returns the [start, end) byte offsets of the lines of txt selected by the Address. Like sed, a range
+// starting with a pattern begins again whenever another line matches it after the range ended.
+func (a Address) lines(txt string, mode Mode, limits *Limits) ([][]int, error) {
+	start, err := compileLineAddress(a.Start, mode, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := compileLineAddress(a.End, mode, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	all := splitLines(txt)
+	selected := [][]int{}
+	inRange := false
+	for i, span := range all {
+		n, line := i+1, txt[span[0]:span[1]]
+		if inRange {
+			selected = append(selected, span)
+			ends, err := end.endsRangeAt(n, line, len(all), false)
+			if err != nil {
+				return nil, err
+			}
+			inRange = !ends
+			continue
+		}
+
+		begins, err := start.matches(n, line, len(all))
+		if err != nil {
+			return nil, err
+		}
+
+		if begins {
+			selected = append(selected, span)
+			ends, err := end.endsRangeAt(n, line, len(all), true)
+			if err != nil {
+				return nil, err
+			}
+			inRange = a.End.Kind != AddressNone && !ends
+		}
+	}
+
+	return selected, nil
+}
+
+// restrictToLines keeps the matches lying within the given lines, grouped by line
+func restrictToLines(matches [][]int, lines [][]int) [][][]int {
+	grouped := make([][][]int, len(lines))
+	i := 0
+	for _, m := range matches {
+		for i < len(lines) && lines[i][1] < m[0] {
+			i++
+		}
+
+		if i == len(lines) {
+			break
+		}
+
+		if lines[i][0] <= m[0] && m[1] <= lines[i][1] {
+			grouped[i] = append(grouped[i], m)
+		}
+	}
+
+	return grouped
+}
+
+// groupMatchesByLine groups the matches lying within the lines selected by the Command's Address by line. Without
+// an Address all matches form a single group.
+func (s *Command) groupMatchesByLine(txt string, matches [][]int, limits *Limits) ([][][]int, error) {
+	if s.Address.IsZero() {
+		return [][][]int{matches}, nil
+	}
+
+	lines, err := s.Address.lines(txt, s.Flags.Mode, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	return restrictToLines(matches, lines), nil
+}
@@ -0,0 +1,74 @@
+package substitution
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitLines(t *testing.T) {
+	cases := []struct {
+		in    string
+		lines [][]int
+	}{
+		{"", [][]int{{0, 0}}},
+		{"a", [][]int{{0, 1}}},
+		{"a\n", [][]int{{0, 1}}},
+		{"a\n\nbc", [][]int{{0, 1}, {2, 2}, {3, 5}}},
+	}
+
+	for _, c := range cases {
+		if lines := splitLines(c.in); !reflect.DeepEqual(lines, c.lines) {
+			t.Errorf("splitLines(%q) should have returned %v but returned %v", c.in, c.lines, lines)
+		}
+	}
+}
+
+func TestAddressLines(t *testing.T) {
+	txt := "one\ntwo\nthree\nfour\nfive"
+	line := func(n int) LineAddress { return LineAddress{Kind: AddressLine, Line: n} }
+	pattern := func(p string) LineAddress { return LineAddress{Kind: AddressPattern, Pattern: p} }
+	last := LineAddress{Kind: AddressLast}
+
+	cases := []struct {
+		address Address
+		lines   []string
+	}{
+		{Address{Start: line(2)}, []string{"two"}},
+		{Address{Start: line(9)}, []string{}},
+		{Address{Start: last}, []string{"five"}},
+		{Address{Start: line(2), End: line(4)}, []string{"two", "three", "four"}},
+		{Address{Start: line(4), End: line(2)}, []string{"four"}}, // Ends before it began
+		{Address{Start: line(1), End: last}, []string{"one", "two", "three", "four", "five"}},
+		{Address{Start: pattern("^t")}, []string{"two", "three"}},
+		{Address{Start: pattern("o"), End: pattern("o")}, []string{"one", "two", "four", "five"}}, // Ranges begin again
+		{Address{Start: pattern("^th"), End: line(1)}, []string{"three"}},
+		{Address{Start: line(4), End: pattern("x")}, []string{"four", "five"}}, // Unended ranges run to the last line
+	}
+
+	for _, c := range cases {
+		spans, err := c.address.lines(txt, ModeVim, &DefaultLimits)
+		if err != nil {
+			t.Errorf("%+v.lines should not have errored but did: %s", c.address, err)
+			continue
+		}
+
+		lines := []string{}
+		for _, span := range spans {
+			lines = append(lines, txt[span[0]:span[1]])
+		}
+
+		if !reflect.DeepEqual(lines, c.lines) {
+			t.Errorf("%+v.lines should have returned %v but returned %v", c.address, c.lines, lines)
+		}
+	}
+}
+
+func TestRestrictToLines(t *testing.T) {
+	matches := [][]int{{0, 1}, {2, 3}, {3, 6}, {7, 8}, {8, 9}}
+	lines := [][]int{{0, 3}, {7, 9}}
+
+	expected := [][][]int{{{0, 1}, {2, 3}}, {{7, 8}, {8, 9}}}
+	if grouped := restrictToLines(matches, lines); !reflect.DeepEqual(grouped, expected) {
+		t.Errorf("restrictToLines(%v, %v) should have returned %v but returned %v", matches, lines, expected, grouped)
+	}
+}
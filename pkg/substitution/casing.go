@@ -0,0 +1,97 @@
+package substitution
+
+import (
+	"strings"
+	"unicode"
+)
+
+// casePattern is the way the letters of a match are cased
+type casePattern int
+
+const (
+	caseUncased     casePattern = iota // no cased letters
+	caseLowercase                      // lowercase
+	caseUppercase                      // UPPERCASE (at least two letters)
+	caseCapitalized                    // Capitalized (a single uppercase letter counts too)
+	caseMixed                          // anything else, e.g. camelCase
+)
+
+// casedLetters returns the letters of txt that have an upper & lower case
+func casedLetters(txt string) []rune {
+	letters := []rune{}
+	for _, r := range txt {
+		if unicode.IsUpper(r) || unicode.IsLower(r) || unicode.IsTitle(r) {
+			letters = append(letters, r)
+		}
+	}
+
+	return letters
+}
+
+func classifyCase(letters []rune) casePattern {
+	if len(letters) == 0 {
+		return caseUncased
+	}
+
+	upper := 0
+	for _, r := range letters {
+		if !unicode.IsLower(r) {
+			upper++
+		}
+	}
+
+	firstUpper := !unicode.IsLower(letters[0])
+	switch {
+	case upper == 0:
+		return caseLowercase
+	case upper == len(letters) && len(letters) > 1:
+		return caseUppercase
+	case firstUpper && upper == 1:
+		return caseCapitalized
+	default:
+		return caseMixed
+	}
+}
+
+// preserveCase adapts the case of replacement to follow the case of match. Mixed case is followed letter by letter
+// with the case of the last letter of match carrying on for any further letters of replacement.
+func preserveCase(match string, replacement string) string {
+	letters := casedLetters(match)
+	switch classifyCase(letters) {
+	case caseLowercase:
+		return strings.ToLower(replacement)
+	case caseUppercase:
+		return strings.ToUpper(replacement)
+	case caseCapitalized:
+		out := strings.Builder{}
+		first := true
+		for _, r := range strings.ToLower(replacement) {
+			if first && unicode.IsLetter(r) {
+				r = unicode.ToTitle(r)
+				first = false
+			}
+			out.WriteRune(r)
+		}
+		return out.String()
+	case caseMixed:
+		out := strings.Builder{}
+		i := 0
+		for _, r := range replacement {
+			if unicode.IsLetter(r) {
+				if unicode.IsLower(letters[i]) {
+					r = unicode.ToLower(r)
+				} else {
+					r = unicode.ToUpper(r)
+				}
+
+				if i < len(letters)-1 {
+					i++
+				}
+			}
+			out.WriteRune(r)
+		}
+		return out.String()
+	default:
+		return replacement
+	}
+}
@@ -0,0 +1,32 @@
+package substitution
+
+import "testing"
+
+func TestPreserveCase(t *testing.T) {
+	cases := []struct {
+		match       string
+		replacement string
+		out         string
+	}{
+		{"cat", "Dog", "dog"},
+		{"CAT", "dog", "DOG"},
+		{"Cat", "dog", "Dog"},
+		{"Cat", "DOG", "Dog"},
+		{"C", "dog", "Dog"}, // A single uppercase letter is taken as capitalized
+		{"cAt", "dogs", "dOgs"},
+		{"cAT", "dogs", "dOGS"}, // Last letter's case carries on
+		{"123", "Dog", "Dog"},
+		{"Éclair", "über", "Über"},
+		{"ÉCLAIR", "über", "ÜBER"},
+		{"straße", "STRASSE", "strasse"},
+		{"Cat", "big dog", "Big dog"},
+		{"Cat", "123 dog", "123 Dog"},
+		{"日本", "dog", "dog"}, // Letters without case don't count
+	}
+
+	for _, c := range cases {
+		if out := preserveCase(c.match, c.replacement); out != c.out {
+			t.Errorf("preserveCase(%q, %q) should have returned %q but returned %q", c.match, c.replacement, c.out, out)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package substitution
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dlclark/regexp2"
+	regexp2syntax "github.com/dlclark/regexp2/syntax"
+)
+
+// Expander expands replacement templates in the syntax of regexp.Expand (*regexp.Regexp is one)
+type Expander interface {
+	ExpandString(dst []byte, template string, src string, match []int) []byte
+}
+
+// Regexp is a pattern compiled by an Engine
+type Regexp interface {
+	Expander
+	// FindAllStringSubmatchIndex is like the method of regexp.Regexp, but may fail (e.g. by running out of time)
+	FindAllStringSubmatchIndex(s string, n int) ([][]int, error)
+}
+
+// Engine compiles patterns into Regexps. Patterns are given in RE2 syntax, which an engine may extend.
+type Engine interface {
+	Name() string
+	Compile(pattern string) (Regexp, error)
+}
+
+// DefaultMatchTimeout bounds how long the backtracking engine may spend matching a command
+const DefaultMatchTimeout = 100 * time.Millisecond
+
+var (
+	// RE2Engine matches in linear time with the regexp package, checking word boundaries against words of any script
+	RE2Engine Engine = re2Engine{}
+	// BacktrackingEngine supports lookarounds & backreferences, giving up after DefaultMatchTimeout. Like RE2Engine,
+	// its word boundaries know words of any script.
+	BacktrackingEngine Engine = NewBacktrackingEngine(DefaultMatchTimeout)
+	// DefaultEngine compiles the patterns of commands without the b flag. It's only meant to be changed at startup.
+	DefaultEngine = RE2Engine
+)
+
+// LookupEngine returns RE2Engine or BacktrackingEngine by name
+func LookupEngine(name string) (Engine, error) {
+	for _, engine := range []Engine{RE2Engine, BacktrackingEngine} {
+		if strings.EqualFold(engine.Name(), name) {
+			return engine, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown engine %q (expected %s or %s)", name, RE2Engine.Name(), BacktrackingEngine.Name())
+}
+
+// MatchTimeoutError is returned when the backtracking engine runs out of time
+type MatchTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *MatchTimeoutError) Error() string {
+	return fmt.Sprintf("matching took longer than %s", e.Timeout)
+}
+
+// programSizer is implemented by the engines that can measure the program a pattern compiles to, which
+// Limits.MaxProgramSize caps
+type programSizer interface {
+	// programSize returns the size of the program pattern compiles to (false when it doesn't compile)
+	programSize(pattern string) (int, bool)
+}
+
+type re2Engine struct{}
+
+func (re2Engine) Name() string {
+	return "re2"
+}
+
+func (re2Engine) Compile(pattern string) (Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return re2Regexp{re, compileWordNFA(pattern)}, nil
+}
+
+// programSize counts the instructions pattern compiles to the same way the regexp package compiles it
+func (re2Engine) programSize(pattern string) (int, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return 0, false
+	}
+
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return 0, false
+	}
+	return len(prog.Inst), true
+}
+
+type re2Regexp struct {
+	*regexp.Regexp
+	words *wordNFA // nil when the pattern has no word boundaries
+}
+
+func (r re2Regexp) FindAllStringSubmatchIndex(s string, n int) ([][]int, error) {
+	// The regexp package's \b only knows ASCII words so text with others is matched by a wordNFA instead
+	if r.words != nil && hasNonASCIIWords(s) {
+		return r.words.findAll(s, n), nil
+	}
+	return r.Regexp.FindAllStringSubmatchIndex(s, n), nil
+}
+
+type backtrackingEngine struct {
+	timeout time.Duration
+}
+
+// NewBacktrackingEngine returns an Engine built on github.com/dlclark/regexp2 that gives up matching a command
+// after timeout
+func NewBacktrackingEngine(timeout time.Duration) Engine {
+	return backtrackingEngine{timeout}
+}
+
+func (backtrackingEngine) Name() string {
+	return "backtracking"
+}
+
+// backtrackingPattern normalizes the patterns RE2 understands since regexp2 doesn't know all of its shorthands
+// (e.g. \pL)
+func backtrackingPattern(pattern string) string {
+	if parsed, err := syntax.Parse(pattern, syntax.Perl); err == nil {
+		return parsed.String()
+	}
+	return pattern
+}
+
+// programSize counts the codes (instructions & their operands) of the program regexp2 compiles pattern to. Unlike
+// RE2, regexp2 doesn't unroll counted repetitions, so its programs are usually smaller.
+func (backtrackingEngine) programSize(pattern string) (int, bool) {
+	tree, err := regexp2syntax.Parse(backtrackingPattern(pattern), regexp2syntax.RE2)
+	if err != nil {
+		return 0, false
+	}
+
+	code, err := regexp2syntax.Write(tree)
+	if err != nil {
+		return 0, false
+	}
+	return len(code.Codes), true
+}
+
+func (e backtrackingEngine) Compile(pattern string) (Regexp, error) {
+	re, err := regexp2.Compile(backtrackingPattern(pattern), regexp2.RE2)
+	if err != nil {
+		return nil, err
+	}
+	re.MatchTimeout = e.timeout
+
+	numbers := re.GetGroupNumbers()
+	sort.Ints(numbers)
+	names := make([]string, len(numbers))
+	for i, n := range numbers {
+		names[i] = re.GroupNameFromNumber(n)
+	}
+
+	return &backtrackingRegexp{re: re, timeout: e.timeout, numbers: numbers, names: names}, nil
+}
+
+type backtrackingRegexp struct {
+	mu      sync.Mutex // guards re.MatchTimeout, which is set anew for each call of regexp2
+	re      *regexp2.Regexp
+	timeout time.Duration
+	numbers []int    // group numbers in order
+	names   []string // group names in the same order (numbered groups are named by their number)
+}
+
+func (r *backtrackingRegexp) FindAllStringSubmatchIndex(s string, n int) ([][]int, error) {
+	// regexp2 works with rune offsets
+	offsets := make([]int, 0, len(s)+1)
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(s))
+
+	// regexp2 holds each call to MatchTimeout, so it's given whatever is left of a single deadline for the command
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deadline := time.Now().Add(r.timeout)
+
+	matches := [][]int{}
+	var m *regexp2.Match
+	for n < 0 || len(matches) < n {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, &MatchTimeoutError{r.timeout}
+		}
+		r.re.MatchTimeout = remaining
+
+		var err error
+		if m == nil {
+			m, err = r.re.FindStringMatch(s)
+		} else {
+			m, err = r.re.FindNextMatch(m)
+		}
+		if err != nil {
+			// regexp2 only tells running out of time apart from other failures by its message, so the deadline is
+			// checked instead
+			if !time.Now().Before(deadline) {
+				return nil, &MatchTimeoutError{r.timeout}
+			}
+			return nil, err
+		}
+		if m == nil {
+			break
+		}
+
+		match := make([]int, 2*len(r.numbers))
+		for i, number := range r.numbers {
+			group := m.GroupByNumber(number)
+			if group == nil || len(group.Captures) == 0 {
+				match[2*i], match[2*i+1] = -1, -1
+				continue
+			}
+			match[2*i], match[2*i+1] = offsets[group.Index], offsets[group.Index+group.Length]
+		}
+		matches = append(matches, match)
+	}
+
+	return matches, nil
+}
+
+func (r *backtrackingRegexp) ExpandString(dst []byte, template string, src string, match []int) []byte {
+	return expandTemplate(dst, template, src, match, r.names)
+}
+
+// expandTemplate expands a template like regexp.Expand with groups named by names (a group with a numeric name is
+// only referred to by its position)
+func expandTemplate(dst []byte, template string, src string, match []int, names []string) []byte {
+	for len(template) > 0 {
+		i := strings.IndexByte(template, '$')
+		if i < 0 {
+			break
+		}
+		dst = append(dst, template[:i]...)
+		template = template[i+1:]
+
+		if strings.HasPrefix(template, "$") {
+			dst = append(dst, '$')
+			template = template[1:]
+			continue
+		}
+
+		name, rest, ok := extractTemplateName(template)
+		if !ok {
+			// Malformed so treat the $ as a literal
+			dst = append(dst, '$')
+			continue
+		}
+		template = rest
+
+		group := -1
+		if strings.Trim(name, "0123456789") == "" {
+			if number, err := strconv.Atoi(name); err == nil {
+				group = number
+			}
+		} else {
+			for j, n := range names {
+				if n == name {
+					group = j
+					break
+				}
+			}
+		}
+
+		if group >= 0 && 2*group+1 < len(match) && match[2*group] >= 0 {
+			dst = append(dst, src[match[2*group]:match[2*group+1]]...)
+		}
+	}
+
+	return append(dst, template...)
+}
+
+// extractTemplateName returns the name following a $ in a template (either $name or ${name})
+func extractTemplateName(template string) (string, string, bool) {
+	brace := strings.HasPrefix(template, "{")
+	if brace {
+		template = template[1:]
+	}
+
+	i := 0
+	for i < len(template) && (template[i] == '_' || ('0' <= template[i] && template[i] <= '9') ||
+		('a' <= template[i] && template[i] <= 'z') || ('A' <= template[i] && template[i] <= 'Z')) {
+		i++
+	}
+
+	if i == 0 {
+		return "", "", false
+	}
+	name := template[:i]
+
+	if brace {
+		if i >= len(template) || template[i] != '}' {
+			return "", "", false
+		}
+		i++
+	}
+
+	return name, template[i:], true
+}
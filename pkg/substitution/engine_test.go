@@ -0,0 +1,123 @@
+package substitution
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEngineFindAll(t *testing.T) {
+	cases := []struct {
+		pattern string
+		in      string
+		matches [][]int
+	}{
+		{`é(.)`, "aébéc", [][]int{{1, 4, 3, 4}, {4, 7, 6, 7}}},
+		{`(a)|(b)`, "ab", [][]int{{0, 1, 0, 1, -1, -1}, {1, 2, -1, -1, 1, 2}}},
+		{`\pL+`, "αβ γ", [][]int{{0, 4}, {5, 7}}},
+		{`(?m:^)x`, "x\nx", [][]int{{0, 1}, {2, 3}}},
+		{`(?P<n>\d)`, "a1", [][]int{{1, 2, 1, 2}}},
+		{`(é|éa)\b`, "éa x", [][]int{{0, 3, 0, 3}}}, // Word boundaries of any script
+	}
+
+	for _, engine := range []Engine{RE2Engine, BacktrackingEngine} {
+		for _, c := range cases {
+			re, err := engine.Compile(c.pattern)
+			if err != nil {
+				t.Errorf("%s.Compile(%s) should not have errored but did: %s", engine.Name(), c.pattern, err)
+				continue
+			}
+
+			matches, err := re.FindAllStringSubmatchIndex(c.in, -1)
+			if err != nil || !reflect.DeepEqual(matches, c.matches) {
+				t.Errorf("%s FindAllStringSubmatchIndex(%s, %q) should have returned %v but returned (%v, %v)", engine.Name(), c.pattern, c.in, c.matches, matches, err)
+			}
+		}
+	}
+}
+
+func TestBacktrackingEngineTimeout(t *testing.T) {
+	re, err := NewBacktrackingEngine(10 * time.Millisecond).Compile(`(a+)+$`)
+	if err != nil {
+		t.Fatalf("Compile should not have errored but did: %s", err)
+	}
+
+	var timeoutErr *MatchTimeoutError
+	if _, err := re.FindAllStringSubmatchIndex(strings.Repeat("a", 40)+"b", -1); !errors.As(err, &timeoutErr) {
+		t.Errorf("FindAllStringSubmatchIndex should have returned a *MatchTimeoutError but returned %v", err)
+	}
+}
+
+func TestBacktrackingEngineTimeoutAcrossMatches(t *testing.T) {
+	// Every match takes far less than the timeout on its own but the command as a whole is held to it
+	re, err := NewBacktrackingEngine(50 * time.Millisecond).Compile(`(a+)+:|!`)
+	if err != nil {
+		t.Fatalf("Compile should not have errored but did: %s", err)
+	}
+
+	var timeoutErr *MatchTimeoutError
+	if _, err := re.FindAllStringSubmatchIndex(strings.Repeat(strings.Repeat("a", 12)+"!", 200), -1); !errors.As(err, &timeoutErr) {
+		t.Errorf("FindAllStringSubmatchIndex should have returned a *MatchTimeoutError but returned %v", err)
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	re := regexp.MustCompile(`(?P<first>\w)(\w)?`)
+	src := "ab c"
+	templates := []string{"$1", "${2}x", "$first", "${first}!", "$$1", "$", "${", "$3", "$2x", "${-}", "$10", "-$2-"}
+
+	for _, match := range re.FindAllStringSubmatchIndex(src, -1) {
+		for _, template := range templates {
+			expected := string(re.ExpandString(nil, template, src, match))
+			if out := string(expandTemplate(nil, template, src, match, re.SubexpNames())); out != expected {
+				t.Errorf("expandTemplate(%q) on %v should have returned %q like regexp.Expand but returned %q", template, match, expected, out)
+			}
+		}
+	}
+}
+
+func TestLookupEngine(t *testing.T) {
+	for _, engine := range []Engine{RE2Engine, BacktrackingEngine} {
+		if found, err := LookupEngine(strings.ToUpper(engine.Name())); err != nil || found != engine {
+			t.Errorf("LookupEngine(%s) should have returned the engine but returned (%v, %v)", engine.Name(), found, err)
+		}
+	}
+
+	if _, err := LookupEngine("pcre"); err == nil {
+		t.Errorf("LookupEngine(pcre) should have errored but did not")
+	}
+}
+
+func TestBacktrackingCommands(t *testing.T) {
+	cases := []struct {
+		input string
+		in    string
+		out   string
+	}{
+		{`s/(?<=foo)bar/baz/b`, "bar foobar", "bar foobaz"},
+		{`s/(\w+) \1/$1/gb`, "the the cat cat", "the cat"},
+		{`s/\(\w\+\) \(\w\+\)/\2 \1/`, "cat dog", "dog cat"}, // Without the flag VIM syntax is still translated
+		{`s/\bcafé\b/tea/b`, "cafés café", "cafés tea"},
+	}
+
+	for _, c := range cases {
+		cmd, err := ParseSubstitutionCommand(c.input)
+		if err != nil {
+			t.Errorf("ParseSubstitutionCommand(%s) should not have errored but did: %s", c.input, err)
+			continue
+		}
+
+		if out, err := cmd.Run(c.in); err != nil || out != c.out {
+			t.Errorf("%s on %q should have returned %q but returned (%q, %v)", c.input, c.in, c.out, out, err)
+		}
+	}
+
+	// VIM syntax runs on the backtracking engine when it's chosen explicitly
+	cmd := Command{ToReplace: `\v<(\w+)>`, ReplaceWith: `[\1]`, Flags: Flags{Global: true, Backtracking: true}}
+	if out, err := cmd.Run("a bc"); err != nil || out != "[a] [bc]" {
+		t.Errorf("%+v.Run(a bc) should have returned [a] [bc] but returned (%q, %v)", cmd, out, err)
+	}
+}
@@ -0,0 +1,253 @@
+package substitution
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxExplainedMatches caps how many matches an explanation lists
+const maxExplainedMatches = 10
+
+// Character classes worth naming rather than listing (as sorted pairs of rune ranges)
+var namedClasses = []struct {
+	ranges []rune
+	name   string
+}{
+	{[]rune{'0', '9'}, "a digit"},
+	{[]rune{'0', '9', 'A', 'Z', '_', '_', 'a', 'z'}, "a word character (letter, digit or _)"},
+	{[]rune{'\t', '\n', '\f', '\r', ' ', ' '}, "a whitespace character"},
+	{[]rune{'\t', '\t', ' ', ' '}, "a space or tab"},
+	{[]rune{'A', 'Z', 'a', 'z'}, "an ASCII letter"},
+}
+
+// codeSpan wraps txt in a code span, using more backticks than any run of them within txt
+func codeSpan(txt string) string {
+	longest, run := 0, 0
+	for _, r := range txt {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+
+	fence := strings.Repeat("`", longest+1)
+	if strings.HasPrefix(txt, "`") || strings.HasSuffix(txt, "`") {
+		txt = " " + txt + " "
+	}
+
+	return fence + txt + fence
+}
+
+// printable spells out txt with Go's escapes when it holds unprintable characters (e.g. line breaks)
+func printable(txt string) string {
+	for _, r := range txt {
+		if !unicode.IsPrint(r) {
+			quoted := strconv.Quote(txt)
+			return quoted[1 : len(quoted)-1]
+		}
+	}
+
+	return txt
+}
+
+func describeClassRune(r rune) string {
+	switch {
+	case strings.ContainsRune(`\]-^`, r):
+		return `\` + string(r)
+	case unicode.IsPrint(r) && r != ' ':
+		return string(r)
+	case r == ' ':
+		return " "
+	default:
+		return printable(string(r))
+	}
+}
+
+// describeClass describes a character class given as sorted pairs of rune ranges
+func describeClass(ranges []rune) string {
+	negated := len(ranges) > 0 && ranges[0] == 0 && ranges[len(ranges)-1] == unicode.MaxRune
+	if negated {
+		complement := []rune{}
+		next := rune(0)
+		for i := 0; i < len(ranges); i += 2 {
+			if ranges[i] > next {
+				complement = append(complement, next, ranges[i]-1)
+			}
+			next = ranges[i+1] + 1
+		}
+		ranges = complement
+	}
+
+	description := ""
+	for _, named := range namedClasses {
+		if len(named.ranges) == len(ranges) && string(named.ranges) == string(ranges) {
+			description = named.name
+		}
+	}
+
+	if len(description) == 0 {
+		if len(ranges) > 16 {
+			description = fmt.Sprintf("a character from %d ranges (such as a Unicode category)", len(ranges)/2)
+		} else {
+			class := strings.Builder{}
+			for i := 0; i < len(ranges); i += 2 {
+				class.WriteString(describeClassRune(ranges[i]))
+				if ranges[i+1] != ranges[i] {
+					class.WriteString("-" + describeClassRune(ranges[i+1]))
+				}
+			}
+			description = "one of " + codeSpan("["+class.String()+"]")
+		}
+	}
+
+	switch {
+	case negated && len(ranges) == 0:
+		return "any character"
+	case negated && ranges[0] == '\n' && ranges[1] == '\n' && len(ranges) == 2:
+		return "any character except a line break"
+	case negated:
+		return "any character except " + description
+	default:
+		return description
+	}
+}
+
+// describeNode describes a single node of a parsed pattern (without its children)
+func describeNode(re *syntax.Regexp) string {
+	lazy := ""
+	if re.Flags&syntax.NonGreedy != 0 {
+		lazy = " (as few as possible)"
+	}
+
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return "nothing (never matches)"
+	case syntax.OpEmptyMatch:
+		return "nothing (an empty match)"
+	case syntax.OpLiteral:
+		noun := "the text"
+		if len(re.Rune) == 1 {
+			noun = "the character"
+		}
+
+		if re.Flags&syntax.FoldCase != 0 {
+			// Folded literals are kept in upper case
+			return noun + " " + codeSpan(printable(strings.ToLower(string(re.Rune)))) + " (ignoring case)"
+		}
+		return noun + " " + codeSpan(printable(string(re.Rune)))
+	case syntax.OpCharClass:
+		return describeClass(re.Rune)
+	case syntax.OpAnyCharNotNL:
+		return "any character except a line break"
+	case syntax.OpAnyChar:
+		return "any character (including line breaks)"
+	case syntax.OpBeginLine:
+		return "the start of a line"
+	case syntax.OpEndLine:
+		return "the end of a line"
+	case syntax.OpBeginText:
+		return "the start of the comment"
+	case syntax.OpEndText:
+		return "the end of the comment"
+	case syntax.OpWordBoundary:
+		return "a word boundary"
+	case syntax.OpNoWordBoundary:
+		return "a position that isn't a word boundary"
+	case syntax.OpCapture:
+		if len(re.Name) > 0 {
+			return fmt.Sprintf("group %d (named %s), capturing:", re.Cap, codeSpan(re.Name))
+		}
+		return fmt.Sprintf("group %d, capturing:", re.Cap)
+	case syntax.OpStar:
+		return "zero or more" + lazy + " of:"
+	case syntax.OpPlus:
+		return "one or more" + lazy + " of:"
+	case syntax.OpQuest:
+		return "optionally" + lazy + ":"
+	case syntax.OpRepeat:
+		switch {
+		case re.Max < 0:
+			return fmt.Sprintf("at least %d%s of:", re.Min, lazy)
+		case re.Min == re.Max:
+			return fmt.Sprintf("exactly %d of:", re.Min)
+		default:
+			return fmt.Sprintf("between %d & %d%s of:", re.Min, re.Max, lazy)
+		}
+	case syntax.OpAlternate:
+		return "one of these alternatives:"
+	default:
+		return "the sequence:"
+	}
+}
+
+// describeRegexp writes a nested markdown list describing a parsed pattern. The items of a sequence are listed at
+// the same depth.
+func describeRegexp(out *strings.Builder, re *syntax.Regexp, depth int) {
+	if re.Op == syntax.OpConcat {
+		for _, sub := range re.Sub {
+			describeRegexp(out, sub, depth)
+		}
+		return
+	}
+
+	fmt.Fprintf(out, "%s- %s\n", strings.Repeat("  ", depth), describeNode(re))
+	for _, sub := range re.Sub {
+		// Sequences are only flattened into groups, elsewhere they need an item of their own
+		if sub.Op == syntax.OpConcat && re.Op != syntax.OpCapture {
+			fmt.Fprintf(out, "%s- %s\n", strings.Repeat("  ", depth+1), describeNode(sub))
+			describeRegexp(out, sub, depth+2)
+			continue
+		}
+		describeRegexp(out, sub, depth+1)
+	}
+}
+
+// explain describes the pattern of a command in markdown along with the matches of it that were found in txt
+func (s *Command) explain(pattern *matcher, txt string, matches [][]int) string {
+	out := strings.Builder{}
+	fmt.Fprintf(&out, "**Pattern** %s", codeSpan(printable(s.ToReplace)))
+	if pattern.source != s.ToReplace {
+		fmt.Fprintf(&out, " (%s in Go's syntax)", codeSpan(printable(pattern.source)))
+	}
+	out.WriteString(" matches:\n\n")
+
+	parsed, err := syntax.Parse(pattern.source, syntax.Perl)
+	if err != nil {
+		out.WriteString("- something only the backtracking engine understands, so it can't be broken down\n")
+	} else {
+		describeRegexp(&out, parsed, 0)
+	}
+
+	if s.Flags.WholeWord {
+		out.WriteString("- only as a whole word\n")
+	}
+
+	switch len(matches) {
+	case 0:
+		out.WriteString("\n**No matches** in the comment")
+		return out.String()
+	case 1:
+		out.WriteString("\n**1 match** in the comment:\n\n")
+	default:
+		fmt.Fprintf(&out, "\n**%d matches** in the comment:\n\n", len(matches))
+	}
+
+	for i, m := range matches {
+		if i == maxExplainedMatches {
+			fmt.Fprintf(&out, "- ...and %d more\n", len(matches)-maxExplainedMatches)
+			break
+		}
+
+		line := strings.Count(txt[:m[0]], "\n") + 1
+		fmt.Fprintf(&out, "- %s on line %d\n", codeSpan(printable(txt[m[0]:m[1]])), line)
+	}
+
+	return strings.TrimSuffix(out.String(), "\n")
+}
@@ -0,0 +1,113 @@
+package substitution
+
+import (
+	"regexp/syntax"
+	"strings"
+	"testing"
+)
+
+func TestCodeSpan(t *testing.T) {
+	cases := []struct {
+		input  string
+		output string
+	}{
+		{"abc", "`abc`"},
+		{"a`b", "``a`b``"},
+		{"a``b`", "``` a``b` ```"},
+	}
+
+	for _, c := range cases {
+		if out := codeSpan(c.input); out != c.output {
+			t.Errorf("codeSpan(%s) should have returned %s but returned %s", c.input, c.output, out)
+		}
+	}
+}
+
+func TestDescribeClass(t *testing.T) {
+	cases := []struct {
+		pattern     string
+		description string
+	}{
+		{`\d`, "a digit"},
+		{`\W`, "any character except a word character (letter, digit or _)"},
+		{`[^a\n]`, "any character except one of `[\\na]`"},
+		{`[a-c\]x]`, "one of `[\\]a-cx]`"},
+		{`[^ab\t]`, "any character except one of `[\\ta-b]`"},
+	}
+
+	for _, c := range cases {
+		parsed, err := syntax.Parse(c.pattern, syntax.Perl)
+		if err != nil {
+			t.Errorf("syntax.Parse(%s) should not have errored but did: %s", c.pattern, err)
+			continue
+		}
+
+		if description := describeClass(parsed.Rune); description != c.description {
+			t.Errorf("the class %s should have been described as %q but was described as %q", c.pattern, c.description, description)
+		}
+	}
+}
+
+func TestCommandExplain(t *testing.T) {
+	cases := []struct {
+		in          string
+		cmd         Command
+		explanation string
+	}{
+		{
+			"foo1 bar22\nfoo",
+			Command{ToReplace: `\(foo\|bar\)\d\+`, Flags: Flags{Global: true, Explain: true}},
+			"**Pattern** `\\(foo\\|bar\\)\\d\\+` (`(foo|bar)[0-9]+` in Go's syntax) matches:\n\n" +
+				"- group 1, capturing:\n  - one of these alternatives:\n    - the text `foo`\n    - the text `bar`\n" +
+				"- one or more of:\n  - a digit\n\n" +
+				"**2 matches** in the comment:\n\n- `foo1` on line 1\n- `bar22` on line 1",
+		},
+		{
+			"a\nab",
+			Command{ToReplace: `^(?:a\d)*?b$`, Flags: Flags{Mode: ModeGo, CaseInsensitive: true, WholeWord: true, Explain: true}},
+			"**Pattern** `^(?:a\\d)*?b$` (`(?i)^(?:a\\d)*?b$` in Go's syntax) matches:\n\n" +
+				"- the start of the comment\n- zero or more (as few as possible) of:\n  - the sequence:\n" +
+				"    - the character `a` (ignoring case)\n    - a digit\n" +
+				"- the character `b` (ignoring case)\n- the end of the comment\n- only as a whole word\n\n" +
+				"**No matches** in the comment",
+		},
+		{
+			"ab",
+			Command{ToReplace: `(?<=a)b`, Flags: Flags{Mode: ModeGo, Backtracking: true, Explain: true}},
+			"**Pattern** `(?<=a)b` matches:\n\n" +
+				"- something only the backtracking engine understands, so it can't be broken down\n\n" +
+				"**1 match** in the comment:\n\n- `b` on line 1",
+		},
+		{
+			"a a\na",
+			Command{ToReplace: "a", Flags: Flags{Explain: true}},
+			"**Pattern** `a` matches:\n\n- the character `a`\n\n" +
+				"**3 matches** in the comment:\n\n- `a` on line 1\n- `a` on line 1\n- `a` on line 2",
+		},
+	}
+
+	for _, c := range cases {
+		result, err := c.cmd.Apply(c.in)
+		if err != nil {
+			t.Errorf("%+v.Apply(%s) should not have errored but did: %s", c.cmd, c.in, err)
+			continue
+		}
+
+		if result.Output != c.in || len(result.Changes) > 0 || result.Explanation != c.explanation {
+			t.Errorf("%+v.Apply(%s) should have explained\n%s\nbut returned %+v", c.cmd, c.in, c.explanation, *result)
+		}
+	}
+}
+
+func TestCommandExplainManyMatches(t *testing.T) {
+	cmd := Command{ToReplace: "a", Flags: Flags{Global: true, Explain: true}}
+	result, err := cmd.Apply(strings.Repeat("a\n", maxExplainedMatches+3))
+	if err != nil {
+		t.Errorf("%+v.Apply should not have errored but did: %s", cmd, err)
+		return
+	}
+
+	if !strings.HasSuffix(result.Explanation, "- `a` on line 10\n- ...and 3 more") {
+		t.Errorf("%+v.Apply should have listed %d matches & summarized the rest but returned %s", cmd, maxExplainedMatches, result.Explanation)
+	}
+}
@@ -0,0 +1,129 @@
+package substitution
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Flags represents the modifiers given after the final delimiter of a substitution command
+type Flags struct {
+	Global          bool // g - replace all matches instead of only the first
+	CaseInsensitive bool // i - match without regard to case
+	Occurrence      int  // N - replace only the Nth match (or the Nth onwards when combined with g)
+	Mode            Mode // R - use Go's regexp syntax (ModeGo) & l - match plain text (ModeLiteral) instead of VIM's syntax
+	Markdown        bool // m - only substitute markdown text, leaving code, links & markup alone
+	IncludeCode     bool // c - with m, also substitute inside inline code & code blocks
+	IncludeLinks    bool // u - with m, also substitute inside link destinations & URLs
+	CountOnly       bool // n - report every match of the pattern instead of replacing anything (ignoring g & N)
+	PreserveCase    bool // p - adapt the case of each replacement to the case of the text it replaces
+	WholeWord       bool // w - only replace matches beginning & ending at word boundaries (of any script)
+	Backtracking    bool // b - match with BacktrackingEngine (implying Go's syntax unless l is given)
+	Explain         bool // ? - describe the pattern & what it matches instead of replacing
+}
+
+// FlagError is returned when the flags of a substitution command can't be parsed
+type FlagError struct {
+	Flags  string
+	Offset int
+	Reason string
+}
+
+func (e *FlagError) Error() string {
+	return fmt.Sprintf("invalid flags %q at offset %d: %s", e.Flags, e.Offset, e.Reason)
+}
+
+// ParseFlags parses the trailing flag segment of a substitution command (e.g. "gi" or "2")
+func ParseFlags(txt string) (Flags, error) {
+	flags := Flags{}
+
+	for i := 0; i < len(txt); i++ {
+		switch c := txt[i]; {
+		case c == 'g':
+			flags.Global = true
+		case c == 'i':
+			flags.CaseInsensitive = true
+		case c == 'm':
+			flags.Markdown = true
+		case c == 'c':
+			flags.IncludeCode = true
+		case c == 'u':
+			flags.IncludeLinks = true
+		case c == 'n':
+			flags.CountOnly = true
+		case c == 'p':
+			flags.PreserveCase = true
+		case c == 'w':
+			flags.WholeWord = true
+		case c == 'b':
+			flags.Backtracking = true
+		case c == '?':
+			flags.Explain = true
+		case c == 'R' || c == 'l':
+			mode := ModeGo
+			if c == 'l' {
+				mode = ModeLiteral
+			}
+
+			if flags.Mode != ModeVim && flags.Mode != mode {
+				return Flags{}, &FlagError{txt, i, "conflicting modes"}
+			}
+			flags.Mode = mode
+		case c >= '0' && c <= '9':
+			if flags.Occurrence != 0 {
+				return Flags{}, &FlagError{txt, i, "occurrence given more than once"}
+			}
+
+			end := i + 1
+			for end < len(txt) && txt[end] >= '0' && txt[end] <= '9' {
+				end++
+			}
+
+			n, err := strconv.Atoi(txt[i:end])
+			if err != nil || n == 0 {
+				return Flags{}, &FlagError{txt, i, "occurrence must be a positive number"}
+			}
+
+			flags.Occurrence = n
+			i = end - 1
+		default:
+			return Flags{}, &FlagError{txt, i, fmt.Sprintf("unknown flag %q", c)}
+		}
+	}
+
+	// Lookarounds & backreferences can only be written in Go's (Perl like) syntax
+	if flags.Backtracking && flags.Mode == ModeVim {
+		flags.Mode = ModeGo
+	}
+
+	return flags, nil
+}
+
+// matchLimit returns how many matches need to be found to satisfy the flags (-1 meaning all of them)
+func (f Flags) matchLimit() int {
+	switch {
+	case f.Global || f.Markdown || f.CountOnly:
+		return -1
+	case f.Occurrence > 0:
+		return f.Occurrence
+	default:
+		return 1
+	}
+}
+
+// selectMatches narrows down all found matches to the ones that should be replaced
+func (f Flags) selectMatches(matches [][]int) [][]int {
+	n := f.Occurrence
+	if n == 0 {
+		n = 1
+	}
+
+	if len(matches) < n {
+		return nil
+	}
+
+	if f.Global {
+		return matches[n-1:]
+	}
+
+	return matches[n-1 : n]
+}
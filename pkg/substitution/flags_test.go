@@ -0,0 +1,59 @@
+package substitution
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	cases := []struct {
+		input  string
+		flags  Flags
+		err    bool
+		offset int
+	}{
+		{"", Flags{}, false, 0},
+		{"g", Flags{Global: true}, false, 0},
+		{"i", Flags{CaseInsensitive: true}, false, 0},
+		{"ig", Flags{Global: true, CaseInsensitive: true}, false, 0},
+		{"12", Flags{Occurrence: 12}, false, 0},
+		{"R", Flags{Mode: ModeGo}, false, 0},
+		{"gl", Flags{Global: true, Mode: ModeLiteral}, false, 0},
+		{"lR", Flags{}, true, 1},
+		{"mcu", Flags{Markdown: true, IncludeCode: true, IncludeLinks: true}, false, 0},
+		{"gn", Flags{Global: true, CountOnly: true}, false, 0},
+		{"gip", Flags{Global: true, CaseInsensitive: true, PreserveCase: true}, false, 0},
+		{"w", Flags{WholeWord: true}, false, 0},
+		{"b", Flags{Backtracking: true, Mode: ModeGo}, false, 0},
+		{"g?", Flags{Global: true, Explain: true}, false, 0},
+		{"bl", Flags{Backtracking: true, Mode: ModeLiteral}, false, 0},
+		{"g3i", Flags{Global: true, CaseInsensitive: true, Occurrence: 3}, false, 0},
+		{"0", Flags{}, true, 0},
+		{"gx", Flags{}, true, 1},
+		{"2g3", Flags{}, true, 2},
+	}
+
+	for _, c := range cases {
+		flags, err := ParseFlags(c.input)
+		if c.err {
+			var flagErr *FlagError
+			if !errors.As(err, &flagErr) {
+				t.Errorf("ParseFlags(%s) should have returned a *FlagError but returned %v", c.input, err)
+				continue
+			}
+
+			if flagErr.Offset != c.offset {
+				t.Errorf("ParseFlags(%s) should have errored at offset %d but errored at %d", c.input, c.offset, flagErr.Offset)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseFlags(%s) should not have errored but did: %s", c.input, err)
+		}
+
+		if flags != c.flags {
+			t.Errorf("ParseFlags(%s) should have returned %+v but returned %+v", c.input, c.flags, flags)
+		}
+	}
+}
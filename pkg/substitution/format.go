@@ -0,0 +1,110 @@
+package substitution
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Delimiters tried in turn when writing a Command out, the first appearing nowhere in it is used
+const textDelimiters = "/|#,:!@~"
+
+// escapeDelimiter escapes each occurrence of delim in txt that isn't escaped already
+func escapeDelimiter(txt string, delim string) string {
+	out := strings.Builder{}
+	for i := 0; i < len(txt); i++ {
+		switch {
+		case txt[i] == '\\' && i+1 < len(txt):
+			out.WriteString(txt[i : i+2])
+			i++
+		case strings.HasPrefix(txt[i:], delim):
+			out.WriteString(`\` + delim)
+			i += len(delim) - 1
+		default:
+			out.WriteByte(txt[i])
+		}
+	}
+
+	return out.String()
+}
+
+// Text writes the flags back out in the form ParseFlags accepts
+func (f Flags) Text() string {
+	out := strings.Builder{}
+	if f.Occurrence > 0 {
+		out.WriteString(strconv.Itoa(f.Occurrence))
+	}
+
+	for _, flag := range []struct {
+		set    bool
+		letter byte
+	}{
+		{f.Global, 'g'},
+		{f.CaseInsensitive, 'i'},
+		// The b flag implies Go's syntax by itself
+		{f.Mode == ModeGo && !f.Backtracking, 'R'},
+		{f.Mode == ModeLiteral, 'l'},
+		{f.Markdown, 'm'},
+		{f.IncludeCode, 'c'},
+		{f.IncludeLinks, 'u'},
+		{f.CountOnly, 'n'},
+		{f.PreserveCase, 'p'},
+		{f.WholeWord, 'w'},
+		{f.Backtracking, 'b'},
+		{f.Explain, '?'},
+	} {
+		if flag.set {
+			out.WriteByte(flag.letter)
+		}
+	}
+
+	return out.String()
+}
+
+func (l LineAddress) text() string {
+	switch l.Kind {
+	case AddressLine:
+		return strconv.Itoa(l.Line)
+	case AddressLast:
+		return "$"
+	case AddressPattern:
+		return "/" + escapeDelimiter(l.Pattern, "/") + "/"
+	default:
+		return ""
+	}
+}
+
+// Text writes the address back out in the form it's parsed from
+func (a Address) Text() string {
+	if a.End.Kind == AddressNone {
+		return a.Start.text()
+	}
+
+	return a.Start.text() + "," + a.End.text()
+}
+
+// Text writes the Command back out in the form it's parsed from, picking a delimiter that it doesn't contain (for
+// commands that have one)
+func (s *Command) Text() string {
+	switch s.Op {
+	case OpDelete:
+		return s.Address.Text() + "d"
+	case OpAppend, OpInsert, OpChange:
+		letters := map[Op]string{OpAppend: "a", OpInsert: "i", OpChange: "c"}
+		return s.Address.Text() + letters[s.Op] + `\` + strings.ReplaceAll(s.ReplaceWith, "\n", "\\\n")
+	}
+
+	delim := "/"
+	for _, d := range textDelimiters {
+		if !strings.ContainsRune(s.ToReplace, d) && !strings.ContainsRune(s.ReplaceWith, d) {
+			delim = string(d)
+			break
+		}
+	}
+
+	op := "s"
+	if s.Op == OpTransliterate {
+		op = "y"
+	}
+
+	return s.Address.Text() + op + delim + escapeDelimiter(s.ToReplace, delim) + delim + escapeDelimiter(s.ReplaceWith, delim) + delim + s.Flags.Text()
+}
@@ -0,0 +1,31 @@
+package substitution
+
+import "testing"
+
+func TestCommandText(t *testing.T) {
+	cases := []struct {
+		cmd  Command
+		text string
+	}{
+		{Command{ToReplace: "a", ReplaceWith: "b"}, "s/a/b/"},
+		{Command{ToReplace: "a/b", ReplaceWith: "c", Flags: Flags{Global: true, Occurrence: 2, Mode: ModeGo}}, "s|a/b|c|2gR"},
+		{Command{ToReplace: "/|#,:!@~", ReplaceWith: "a/b", Flags: Flags{Mode: ModeLiteral}}, `s/\/|#,:!@~/a\/b/l`},
+		{Command{ToReplace: "a", Flags: Flags{Mode: ModeGo, Backtracking: true, CaseInsensitive: true, Explain: true}}, "s/a//ib?"},
+		{Command{Op: OpTransliterate, Address: Address{LineAddress{Kind: AddressPattern, Pattern: `x\/y`}, LineAddress{Kind: AddressLast}}, ToReplace: "ab", ReplaceWith: "cd", Flags: Flags{Markdown: true}}, `/x\/y/,$y/ab/cd/m`},
+		{Command{Op: OpDelete, Address: Address{LineAddress{Kind: AddressLine, Line: 2}, LineAddress{Kind: AddressLine, Line: 4}}}, "2,4d"},
+		{Command{Op: OpAppend, Address: Address{Start: LineAddress{Kind: AddressLast}}, ReplaceWith: "two\nlines"}, "$a\\two\\\nlines"},
+		{Command{Address: Address{Start: LineAddress{Kind: AddressLine, Line: 3}}, ToReplace: "a", ReplaceWith: "b", Flags: Flags{WholeWord: true, PreserveCase: true, CountOnly: true}}, "3s/a/b/npw"},
+	}
+
+	for _, c := range cases {
+		if text := c.cmd.Text(); text != c.text {
+			t.Errorf("%+v.Text() should have returned %s but returned %s", c.cmd, c.text, text)
+		}
+
+		// Commands written out parse back into themselves
+		parsed, err := ParseSubstitutionCommand(c.cmd.Text())
+		if err != nil || *parsed != c.cmd {
+			t.Errorf("ParseSubstitutionCommand(%s) should have returned %+v but returned (%+v, %v)", c.text, c.cmd, parsed, err)
+		}
+	}
+}
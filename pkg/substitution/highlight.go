@@ -0,0 +1,126 @@
+package substitution
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// HighlightStyle determines how the changes of a Result are marked up when rendered as markdown
+type HighlightStyle int
+
+const (
+	// HighlightBold renders new text in bold (removed text is struck through when nothing replaced it)
+	HighlightBold HighlightStyle = iota
+	// HighlightStrikethrough renders removed text struck through followed by the new text
+	HighlightStrikethrough
+	// HighlightDiff renders changes like a word diff ([-removed-]{+new+})
+	HighlightDiff
+	// HighlightNone renders the output as is
+	HighlightNone
+)
+
+var highlightStyleNames = []string{"bold", "strikethrough", "diff", "none"}
+
+func (s HighlightStyle) String() string {
+	if int(s) < len(highlightStyleNames) {
+		return highlightStyleNames[s]
+	}
+	return fmt.Sprintf("HighlightStyle(%d)", int(s))
+}
+
+// ParseHighlightStyle looks up a HighlightStyle by name (e.g. "bold" or "diff")
+func ParseHighlightStyle(name string) (HighlightStyle, error) {
+	for i, n := range highlightStyleNames {
+		if strings.EqualFold(n, name) {
+			return HighlightStyle(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown highlight style %q (expected one of %s)", name, strings.Join(highlightStyleNames, ", "))
+}
+
+// wrapMarkup surrounds each line of txt with the given markup. Surrounding whitespace is kept outside of the markup
+// since markdown doesn't recognize emphasis that starts or ends with a space (or spans lines).
+func wrapMarkup(txt string, open string, close string) string {
+	lines := strings.Split(txt, "\n")
+	for i, line := range lines {
+		core := strings.TrimFunc(line, unicode.IsSpace)
+		if len(core) == 0 {
+			continue
+		}
+
+		start := strings.Index(line, core)
+		lines[i] = line[:start] + open + core + close + line[start+len(core):]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s HighlightStyle) render(old string, new string) string {
+	switch s {
+	case HighlightBold:
+		if len(new) == 0 {
+			return wrapMarkup(old, "~~", "~~")
+		}
+		return wrapMarkup(new, "**", "**")
+	case HighlightStrikethrough:
+		return wrapMarkup(old, "~~", "~~") + new
+	case HighlightDiff:
+		return wrapMarkup(old, "[-", "-]") + wrapMarkup(new, "{+", "+}")
+	default:
+		return new
+	}
+}
+
+// render writes the output of r with its changes marked up in style (except those that are plain), returning the
+// spans the changes ended up at
+func (r *Result) render(style HighlightStyle, plain []bool) (string, [][]int) {
+	out := strings.Builder{}
+	spans := make([][]int, len(r.Changes))
+	last := 0
+	for i, c := range r.Changes {
+		out.WriteString(r.Output[last:c.Start])
+		start := out.Len()
+		if plain[i] {
+			out.WriteString(r.Output[c.Start:c.End])
+		} else {
+			out.WriteString(style.render(c.Old, r.Output[c.Start:c.End]))
+		}
+		spans[i] = []int{start, out.Len()}
+		last = c.End
+	}
+	out.WriteString(r.Output[last:])
+
+	return out.String(), spans
+}
+
+// Highlight renders the output of a Result as markdown with its changes marked up in the given style. Markup is
+// never left inside code or link destinations, where it would show as is (or break the link): changes whose markup
+// ends up there are rendered without it.
+func (r *Result) Highlight(style HighlightStyle) string {
+	plain := make([]bool, len(r.Changes))
+	for {
+		out, spans := r.render(style, plain)
+		if style == HighlightNone {
+			return out
+		}
+
+		// Dropping the markup of a change may in turn move others in or out of code, so check again until it settles
+		settled := true
+		for _, node := range parseMarkdownNodes(out) {
+			if node.kind != markdownCode && node.kind != markdownLink {
+				continue
+			}
+
+			for i, span := range spans {
+				if !plain[i] && span[0] < node.end && node.start < span[1] {
+					plain[i] = true
+					settled = false
+				}
+			}
+		}
+
+		if settled {
+			return out
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package substitution
+
+import "testing"
+
+func TestResultHighlight(t *testing.T) {
+	result := Result{Output: "a dog sat\nfur  ball ", Changes: []Change{{2, 5, "cat"}, {6, 6, "was "}, {10, 20, "hair"}}}
+
+	cases := []struct {
+		style HighlightStyle
+		out   string
+	}{
+		{HighlightBold, "a **dog** ~~was~~ sat\n**fur  ball** "},
+		{HighlightStrikethrough, "a ~~cat~~dog ~~was~~ sat\n~~hair~~fur  ball "},
+		{HighlightDiff, "a [-cat-]{+dog+} [-was-] sat\n[-hair-]{+fur  ball+} "},
+		{HighlightNone, "a dog sat\nfur  ball "},
+	}
+
+	for _, c := range cases {
+		if out := result.Highlight(c.style); out != c.out {
+			t.Errorf("Highlight(%s) should have returned %q but returned %q", c.style, c.out, out)
+		}
+	}
+}
+
+func TestResultHighlightLeavesCodeAndLinks(t *testing.T) {
+	cases := []struct {
+		result Result
+		out    string
+	}{
+		{Result{Output: "a `b` c", Changes: []Change{{3, 4, "x"}, {6, 7, "y"}}}, "a `b` **c**"},
+		{Result{Output: "a `` c", Changes: []Change{{3, 3, "b"}}}, "a `` c"}, // Struck through b would become code
+		{Result{Output: "[a](https://b.c)", Changes: []Change{{1, 2, "x"}, {12, 13, "d"}}}, "[**a**](https://b.c)"},
+		{Result{Output: "see https://b.c/d now", Changes: []Change{{15, 17, "/e"}, {18, 21, "then"}}}, "see https://b.c/d **now**"},
+	}
+
+	for _, c := range cases {
+		if out := c.result.Highlight(HighlightBold); out != c.out {
+			t.Errorf("Highlight(bold) of %q should have returned %q but returned %q", c.result.Output, c.out, out)
+		}
+	}
+}
+
+func TestWrapMarkup(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"a", "**a**"},
+		{" a b ", " **a b** "},
+		{"a\n\nb", "**a**\n\n**b**"},
+		{" ", " "},
+		{"", ""},
+	}
+
+	for _, c := range cases {
+		if out := wrapMarkup(c.in, "**", "**"); out != c.out {
+			t.Errorf("wrapMarkup(%q) should have returned %q but returned %q", c.in, c.out, out)
+		}
+	}
+}
+
+func TestParseHighlightStyle(t *testing.T) {
+	for _, style := range []HighlightStyle{HighlightBold, HighlightStrikethrough, HighlightDiff, HighlightNone} {
+		parsed, err := ParseHighlightStyle(style.String())
+		if err != nil || parsed != style {
+			t.Errorf("ParseHighlightStyle(%s) should have returned %s but returned (%s, %v)", style, style, parsed, err)
+		}
+	}
+
+	if _, err := ParseHighlightStyle("italic"); err == nil {
+		t.Errorf("ParseHighlightStyle(italic) should have errored but did not")
+	}
+}
@@ -0,0 +1,246 @@
+package substitution
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Hint is a near miss of a Command that matched nothing: a variation of it that does match
+type Hint struct {
+	Command Command
+	Reason  string
+}
+
+func (h Hint) String() string {
+	return fmt.Sprintf("did you mean %s (%s)?", codeSpan(h.Command.Text()), h.Reason)
+}
+
+// Characters that are special without a backslash in a VIM pattern (as it starts out)
+const vimLiteralSpecials = `\.*[~^$`
+
+// minHintPatternLength is how many characters a pattern needs before near misses of it are looked for
+const minHintPatternLength = 3
+
+// maxHintPatternLength caps how long a pattern may be for similar text to be looked for, since comparing texts takes
+// time quadratic in their length
+const maxHintPatternLength = 100
+
+// maxHintCandidates caps how many runs of words similar text is looked for in
+const maxHintCandidates = 1000
+
+var patternWhitespace = regexp.MustCompile(`[ \t]+`)
+
+// isLiteralPattern reports whether a pattern written in the syntax of mode only matches itself
+func isLiteralPattern(pattern string, mode Mode) bool {
+	switch mode {
+	case ModeLiteral:
+		return true
+	case ModeGo:
+		return regexp.QuoteMeta(pattern) == pattern
+	default:
+		return !strings.ContainsAny(pattern, vimLiteralSpecials)
+	}
+}
+
+// quotePattern returns a pattern in the syntax of mode that matches txt literally
+func quotePattern(txt string, mode Mode) string {
+	switch mode {
+	case ModeLiteral:
+		return txt
+	case ModeGo:
+		return regexp.QuoteMeta(txt)
+	default:
+		out := strings.Builder{}
+		for _, r := range txt {
+			if strings.ContainsRune(vimLiteralSpecials, r) {
+				out.WriteByte('\\')
+			}
+			out.WriteRune(r)
+		}
+		return out.String()
+	}
+}
+
+// editDistance returns how many insertions, deletions, substitutions & transpositions of adjacent runes it takes to
+// turn a into b (without editing any rune twice)
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = d[i-1][j-1] + cost
+			if d[i-1][j]+1 < d[i][j] {
+				d[i][j] = d[i-1][j] + 1
+			}
+			if d[i][j-1]+1 < d[i][j] {
+				d[i][j] = d[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
+
+// words returns the [start, end) byte offsets of the whitespace separated words of txt, trimmed of punctuation
+func words(txt string) [][]int {
+	spans := [][]int{}
+	for start := 0; start < len(txt); {
+		r, size := utf8.DecodeRuneInString(txt[start:])
+		if unicode.IsSpace(r) {
+			start += size
+			continue
+		}
+
+		end := start
+		for end < len(txt) {
+			r, size := utf8.DecodeRuneInString(txt[end:])
+			if unicode.IsSpace(r) {
+				break
+			}
+			end += size
+		}
+
+		word := strings.TrimFunc(txt[start:end], unicode.IsPunct)
+		if len(word) > 0 {
+			offset := start + strings.Index(txt[start:end], word)
+			spans = append(spans, []int{offset, offset + len(word)})
+		}
+		start = end
+	}
+
+	return spans
+}
+
+// normaliseWhitespace collapses each run of whitespace in txt into a single space
+func normaliseWhitespace(txt string) string {
+	return strings.Join(strings.Fields(txt), " ")
+}
+
+// closestText finds the run of words in txt closest to a literal pattern, as long as it's within a quarter of the
+// pattern's length in edits (or a single edit for short patterns) & doesn't only differ in case or whitespace. Only
+// the first maxHintCandidates runs of about the right length are compared.
+func closestText(txt string, literal string) (string, bool) {
+	length := utf8.RuneCountInString(literal)
+	if length < minHintPatternLength || length > maxHintPatternLength {
+		return "", false
+	}
+
+	n := len(strings.Fields(literal))
+	spans := words(txt)
+	maxDistance := length / 4
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+
+	best, bestDistance, compared := "", maxDistance+1, 0
+	for i := 0; n > 0 && i+n <= len(spans) && compared < maxHintCandidates; i++ {
+		candidate := txt[spans[i][0]:spans[i+n-1][1]]
+
+		// Texts of too different a length can't be within the distance allowed
+		if diff := utf8.RuneCountInString(candidate) - length; diff > maxDistance || diff < -maxDistance {
+			continue
+		}
+
+		if strings.EqualFold(normaliseWhitespace(candidate), normaliseWhitespace(literal)) {
+			continue
+		}
+
+		compared++
+
+		if distance := editDistance(candidate, literal); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+
+	return best, len(best) > 0
+}
+
+// Hints looks for variations of a Command that matched nothing in txt which would have matched: ignoring case,
+// allowing any whitespace where the pattern has some & replacing a literal pattern with similar text from txt. No
+// hints are given for a Command that matches, or isn't a substitution.
+func (s *Command) Hints(txt string, limits *Limits) []Hint {
+	if s.Op != OpSubstitute {
+		return nil
+	}
+
+	counted := *s
+	counted.Flags.CountOnly, counted.Flags.Explain = true, false
+	result, err := counted.ApplyWithLimits(txt, limits)
+	if err != nil || len(result.Matches) > 0 {
+		return nil
+	}
+
+	candidates := []Hint{}
+	if !s.Flags.CaseInsensitive {
+		variation := *s
+		variation.Flags.CaseInsensitive = true
+		candidates = append(candidates, Hint{variation, "ignoring case"})
+	}
+
+	if patternWhitespace.MatchString(s.ToReplace) {
+		variation := *s
+		switch s.Flags.Mode {
+		case ModeVim:
+			variation.ToReplace = patternWhitespace.ReplaceAllLiteralString(s.ToReplace, `\_s\+`)
+		case ModeGo:
+			variation.ToReplace = patternWhitespace.ReplaceAllLiteralString(s.ToReplace, `\s+`)
+		case ModeLiteral:
+			// Plain text can't match whitespace loosely so Go's syntax is used instead
+			parts := patternWhitespace.Split(s.ToReplace, -1)
+			for i := range parts {
+				parts[i] = regexp.QuoteMeta(parts[i])
+			}
+			variation.ToReplace = strings.Join(parts, `\s+`)
+			variation.ReplaceWith = strings.ReplaceAll(s.ReplaceWith, "$", "$$")
+			variation.Flags.Mode = ModeGo
+		}
+		candidates = append(candidates, Hint{variation, "allowing for any whitespace"})
+	}
+
+	if isLiteralPattern(s.ToReplace, s.Flags.Mode) {
+		if similar, ok := closestText(txt, s.ToReplace); ok {
+			variation := *s
+			variation.ToReplace = quotePattern(similar, s.Flags.Mode)
+			candidates = append(candidates, Hint{variation, fmt.Sprintf("the comment has %s", codeSpan(printable(similar)))})
+		}
+	}
+
+	// Only variations that actually change something are worth suggesting
+	hints := []Hint{}
+	for _, candidate := range candidates {
+		if _, err := candidate.Command.ApplyWithLimits(txt, limits); err == nil {
+			hints = append(hints, candidate)
+		}
+	}
+
+	return hints
+}
+
+// Hints gathers the hints of each Command of a Pipeline that changed nothing, trying each of them against txt itself
+func (p Pipeline) Hints(txt string, limits *Limits) []Hint {
+	hints := []Hint{}
+	for i := range p {
+		hints = append(hints, p[i].Hints(txt, limits)...)
+	}
+
+	return hints
+}
@@ -0,0 +1,88 @@
+package substitution
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a        string
+		b        string
+		distance int
+	}{
+		{"", "", 0},
+		{"abc", "abc", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"teh", "the", 1},
+		{"café", "cafe", 1},
+	}
+
+	for _, c := range cases {
+		if distance := editDistance(c.a, c.b); distance != c.distance {
+			t.Errorf("editDistance(%s, %s) should have returned %d but returned %d", c.a, c.b, c.distance, distance)
+		}
+	}
+}
+
+func TestClosestText(t *testing.T) {
+	cases := []struct {
+		txt     string
+		literal string
+		closest string
+	}{
+		{"the recieve button", "receive", "recieve"},
+		{"a receiving line", "receive", ""},                      // Too long to be within a single edit
+		{"the Receive button", "receive", ""},                    // Only differs in case
+		{"see the quick brown fox", "quikc brwn", "quick brown"}, // Runs of as many words as the pattern
+		{"ab", "ab", ""}, // Too short to look for
+		{strings.Repeat("x", maxHintPatternLength), strings.Repeat("x", maxHintPatternLength) + "y", ""},
+	}
+
+	for _, c := range cases {
+		closest, ok := closestText(c.txt, c.literal)
+		if closest != c.closest || ok != (len(c.closest) > 0) {
+			t.Errorf("closestText(%q, %q) should have returned %q but returned (%q, %t)", c.txt, c.literal, c.closest, closest, ok)
+		}
+	}
+}
+
+func TestClosestTextCapsCandidates(t *testing.T) {
+	txt := strings.Repeat("recipe ", maxHintCandidates) + "recieve"
+	if closest, ok := closestText(txt, "receive"); ok {
+		t.Errorf("closestText should have stopped after %d candidates but returned %q", maxHintCandidates, closest)
+	}
+}
+
+func TestCommandHints(t *testing.T) {
+	cases := []struct {
+		in    string
+		cmd   Command
+		hints []string
+	}{
+		{"Teh cat", Command{ToReplace: "teh", ReplaceWith: "The"}, []string{"did you mean `s/teh/The/i` (ignoring case)?"}},
+		{"the big\n  dog", Command{ToReplace: "big dog", ReplaceWith: "cat", Flags: Flags{Mode: ModeLiteral}}, []string{`did you mean ` + "`" + `s/big\s+dog/cat/R` + "`" + ` (allowing for any whitespace)?`}},
+		{"the big\tdog", Command{ToReplace: "big dog", ReplaceWith: "cat"}, []string{"did you mean `s/big\\_s\\+dog/cat/` (allowing for any whitespace)?"}},
+		{"I recieve it.", Command{ToReplace: "receive", ReplaceWith: "get"}, []string{"did you mean `s/recieve/get/` (the comment has `recieve`)?"}},
+		{"the seperate.*", Command{ToReplace: `separate\.\*`, ReplaceWith: "x", Flags: Flags{Mode: ModeGo}}, []string{}}, // Punctuation is trimmed from the text compared
+		{"I recieve it", Command{ToReplace: "deceive", ReplaceWith: "get"}, []string{}},                                  // Too far from anything
+		{"a b", Command{ToReplace: "a", ReplaceWith: "c"}, nil},                                                          // Matches so there's nothing to hint at
+		{"a b", Command{Op: OpTransliterate, ToReplace: "x", ReplaceWith: "y"}, nil},
+	}
+
+	for _, c := range cases {
+		var hints []string
+		if found := c.cmd.Hints(c.in, nil); found != nil {
+			hints = []string{}
+			for _, h := range found {
+				hints = append(hints, h.String())
+			}
+		}
+
+		if !reflect.DeepEqual(hints, c.hints) {
+			t.Errorf("%+v.Hints(%s) should have returned %q but returned %q", c.cmd, c.in, c.hints, hints)
+		}
+	}
+}
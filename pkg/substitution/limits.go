@@ -0,0 +1,113 @@
+package substitution
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Limits caps the resources a substitution may use. Lengths are counted in characters & a zero field means that
+// aspect is unlimited.
+type Limits struct {
+	MaxPatternLength int // length of ToReplace as written
+	MaxProgramSize   int // size of the compiled pattern (RE2 instructions, or the codes of regexp2's program for b)
+	MaxReplacements  int // number of matches (or lines for line commands) a single command edits
+	MaxOutputLength  int // length of the output of a single command
+}
+
+// DefaultLimits are used whenever no Limits are given. The output is capped at Reddit's comment length limit.
+var DefaultLimits = Limits{
+	MaxPatternLength: 500,
+	MaxProgramSize:   2000,
+	MaxReplacements:  500,
+	MaxOutputLength:  10000,
+}
+
+// PatternTooLongError is returned when the pattern of a command is longer than allowed
+type PatternTooLongError struct {
+	Length int
+	Max    int
+}
+
+func (e *PatternTooLongError) Error() string {
+	return fmt.Sprintf("pattern is %d characters long (more than %d)", e.Length, e.Max)
+}
+
+// ProgramTooLargeError is returned when the pattern of a command compiles to a larger program than allowed
+type ProgramTooLargeError struct {
+	Size int
+	Max  int
+}
+
+func (e *ProgramTooLargeError) Error() string {
+	return fmt.Sprintf("pattern compiles to a program of size %d (more than %d)", e.Size, e.Max)
+}
+
+// TooManyReplacementsError is returned when a command would replace more matches than allowed
+type TooManyReplacementsError struct {
+	Count int
+	Max   int
+}
+
+func (e *TooManyReplacementsError) Error() string {
+	return fmt.Sprintf("%d matches would be replaced (more than %d)", e.Count, e.Max)
+}
+
+// OutputTooLongError is returned when the output of a command is longer than allowed. Length is a lower bound when
+// building the output was abandoned early.
+type OutputTooLongError struct {
+	Length int
+	Max    int
+}
+
+func (e *OutputTooLongError) Error() string {
+	return fmt.Sprintf("output is at least %d characters long (more than %d)", e.Length, e.Max)
+}
+
+func (l *Limits) checkPatternLength(pattern string) error {
+	if n := utf8.RuneCountInString(pattern); l.MaxPatternLength > 0 && n > l.MaxPatternLength {
+		return &PatternTooLongError{n, l.MaxPatternLength}
+	}
+	return nil
+}
+
+// checkProgramSize measures the program pattern compiles to with the engine that will run it. Engines other than
+// RE2Engine & the backtracking ones can't be measured and aren't limited.
+func (l *Limits) checkProgramSize(engine Engine, pattern string) error {
+	if l.MaxProgramSize <= 0 {
+		return nil
+	}
+
+	sizer, ok := engine.(programSizer)
+	if !ok {
+		return nil
+	}
+
+	// Patterns that don't compile are left for engine.Compile to report
+	if n, ok := sizer.programSize(pattern); ok && n > l.MaxProgramSize {
+		return &ProgramTooLargeError{n, l.MaxProgramSize}
+	}
+	return nil
+}
+
+func (l *Limits) checkReplacements(count int) error {
+	if l.MaxReplacements > 0 && count > l.MaxReplacements {
+		return &TooManyReplacementsError{count, l.MaxReplacements}
+	}
+	return nil
+}
+
+// checkOutputBytes fails once a partially built output holds more bytes than the longest output allowed could
+func (l *Limits) checkOutputBytes(n int) error {
+	if l.MaxOutputLength > 0 && n > utf8.UTFMax*l.MaxOutputLength {
+		return &OutputTooLongError{n / utf8.UTFMax, l.MaxOutputLength}
+	}
+	return nil
+}
+
+// CheckOutputLength returns an *OutputTooLongError when txt is longer than MaxOutputLength
+func (l *Limits) CheckOutputLength(txt string) error {
+	if n := utf8.RuneCountInString(txt); l.MaxOutputLength > 0 && n > l.MaxOutputLength {
+		return &OutputTooLongError{n, l.MaxOutputLength}
+	}
+	return nil
+}
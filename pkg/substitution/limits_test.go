@@ -0,0 +1,71 @@
+package substitution
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyWithLimits(t *testing.T) {
+	cases := []struct {
+		in     string
+		cmd    Command
+		limits Limits
+		err    error
+	}{
+		{"abc", Command{ToReplace: "abc", ReplaceWith: "x"}, Limits{MaxPatternLength: 3}, nil},
+		{"abc", Command{ToReplace: "abc", ReplaceWith: "x"}, Limits{MaxPatternLength: 2}, &PatternTooLongError{}},
+		{"ééé", Command{ToReplace: "ééé", ReplaceWith: "x"}, Limits{MaxPatternLength: 3}, nil}, // Characters, not bytes
+		{"aaa", Command{ToReplace: `a\{50}`, ReplaceWith: "x"}, Limits{MaxProgramSize: 20}, &ProgramTooLargeError{}},
+		{"ab", Command{ToReplace: `(?<=a)b`, ReplaceWith: "x", Flags: Flags{Mode: ModeGo, Backtracking: true}}, Limits{MaxProgramSize: 20}, nil},
+		{"ab", Command{ToReplace: `(?<=a)` + strings.Repeat(`(?:b|c)`, 10), ReplaceWith: "x", Flags: Flags{Mode: ModeGo, Backtracking: true}}, Limits{MaxProgramSize: 20}, &ProgramTooLargeError{}},
+		{"aaa", Command{ToReplace: "a", ReplaceWith: "x", Flags: Flags{Global: true}}, Limits{MaxReplacements: 3}, nil},
+		{"aaa", Command{ToReplace: "a", ReplaceWith: "x", Flags: Flags{Global: true}}, Limits{MaxReplacements: 2}, &TooManyReplacementsError{}},
+		{"aaa", Command{Op: OpTransliterate, ToReplace: "a", ReplaceWith: "x"}, Limits{MaxReplacements: 2}, nil}, // Characters aren't replacements
+		{strings.Repeat("banana ", 200), Command{Op: OpTransliterate, ToReplace: "aeiou", ReplaceWith: "eioua"}, DefaultLimits, nil},
+		{"aaa", Command{Op: OpTransliterate, ToReplace: "a", ReplaceWith: "x"}, Limits{MaxOutputLength: 2}, &OutputTooLongError{}},
+		{"a\nb\nc", Command{Op: OpDelete, Address: Address{Start: LineAddress{Kind: AddressLine, Line: 1}, End: LineAddress{Kind: AddressLast}}}, Limits{MaxReplacements: 3}, nil},
+		{"a\nb\nc", Command{Op: OpAppend, ReplaceWith: "x", Address: Address{Start: LineAddress{Kind: AddressLine, Line: 1}, End: LineAddress{Kind: AddressLast}}}, Limits{MaxReplacements: 2}, &TooManyReplacementsError{}},
+		{"aaa", Command{ToReplace: "a", ReplaceWith: "xx", Flags: Flags{Global: true}}, Limits{MaxOutputLength: 6}, nil},
+		{"aaa", Command{ToReplace: "a", ReplaceWith: "xx", Flags: Flags{Global: true}}, Limits{MaxOutputLength: 5}, &OutputTooLongError{}},
+		{"a", Command{ToReplace: "a", ReplaceWith: strings.Repeat("x", 100)}, Limits{MaxOutputLength: 10}, &OutputTooLongError{}}, // Abandoned early
+		{"a", Command{ToReplace: "a", ReplaceWith: strings.Repeat("x", 100)}, Limits{}, nil},
+	}
+
+	for _, c := range cases {
+		_, err := c.cmd.ApplyWithLimits(c.in, &c.limits)
+		if c.err == nil {
+			if err != nil {
+				t.Errorf("Command{%s, %s}.ApplyWithLimits(%s, %+v) should not have errored but did: %s", c.cmd.ToReplace, c.cmd.ReplaceWith, c.in, c.limits, err)
+			}
+			continue
+		}
+
+		if !errors.As(err, reflect.New(reflect.TypeOf(c.err)).Interface()) {
+			t.Errorf("Command{%s, %s}.ApplyWithLimits(%s, %+v) should have returned a %T but returned %v", c.cmd.ToReplace, c.cmd.ReplaceWith, c.in, c.limits, c.err, err)
+		}
+	}
+}
+
+func TestPipelineApplyWithLimits(t *testing.T) {
+	pipeline := Pipeline{{ToReplace: "a", ReplaceWith: "aa", Flags: Flags{Global: true}}, {ToReplace: "a", ReplaceWith: "aa", Flags: Flags{Global: true}}}
+
+	var stageErr *StageError
+	var outputErr *OutputTooLongError
+	_, err := pipeline.ApplyWithLimits("aa", &Limits{MaxOutputLength: 6})
+	if !errors.As(err, &stageErr) || stageErr.Stage != 2 || !errors.As(err, &outputErr) {
+		t.Errorf("%+v.ApplyWithLimits should have failed in stage 2 with an *OutputTooLongError but returned %v", pipeline, err)
+	}
+}
+
+func TestLimitsCheckOutputLength(t *testing.T) {
+	limits := Limits{MaxOutputLength: 2}
+	if err := limits.CheckOutputLength("éé"); err != nil {
+		t.Errorf("CheckOutputLength(éé) should not have errored but did: %s", err)
+	}
+
+	if err := limits.CheckOutputLength("abc"); err == nil {
+		t.Errorf("CheckOutputLength(abc) should have errored but did not")
+	}
+}
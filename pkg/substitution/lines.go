@@ -0,0 +1,81 @@
+package substitution
+
+import "strings"
+
+// editLines runs a line command (OpDelete, OpAppend, OpInsert or OpChange) on the lines selected by its Address, or
+// every line when it has none. A change command replaces each range of lines it's given with a single line of text.
+func (s *Command) editLines(txt string, limits *Limits) (*Result, error) {
+	all := splitLines(txt)
+	selected := all
+	if !s.Address.IsZero() {
+		var err error
+		selected, err = s.Address.lines(txt, s.Flags.Mode, limits)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := limits.checkReplacements(len(selected)); err != nil {
+		return nil, err
+	}
+
+	isSelected := map[int]bool{}
+	for _, span := range selected {
+		isSelected[span[0]] = true
+	}
+
+	result := &Result{}
+	out := strings.Builder{}
+	for i, span := range all {
+		line := txt[span[0]:span[1]]
+		lineBreak := ""
+		if span[1] < len(txt) {
+			lineBreak = "\n"
+		}
+
+		if !isSelected[span[0]] {
+			out.WriteString(line + lineBreak)
+			continue
+		}
+
+		start := out.Len()
+		switch s.Op {
+		case OpDelete:
+			result.addChange(Change{start, start, line + lineBreak})
+		case OpInsert:
+			out.WriteString(s.ReplaceWith + "\n")
+			result.addChange(Change{start, out.Len(), ""})
+			out.WriteString(line + lineBreak)
+		case OpAppend:
+			out.WriteString(line)
+			start = out.Len()
+			out.WriteString("\n" + s.ReplaceWith)
+			result.addChange(Change{start, out.Len(), ""})
+			out.WriteString(lineBreak)
+		case OpChange:
+			// Within a range the text only replaces the last line, absorbing the lines before it
+			if s.Address.End.Kind != AddressNone && i+1 < len(all) && isSelected[all[i+1][0]] {
+				result.addChange(Change{start, start, line + lineBreak})
+				continue
+			}
+			out.WriteString(s.ReplaceWith)
+			result.addChange(Change{start, out.Len(), line})
+			out.WriteString(lineBreak)
+		}
+
+		if err := limits.checkOutputBytes(out.Len()); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Output = out.String()
+	if err := limits.CheckOutputLength(result.Output); err != nil {
+		return nil, err
+	}
+	if result.Output == txt {
+		return nil, ErrNoChange
+	}
+	result.dropUnchanged()
+
+	return result, nil
+}
@@ -0,0 +1,49 @@
+package substitution
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCommandEditLines(t *testing.T) {
+	cases := []struct {
+		in      string
+		cmd     Command
+		out     string
+		changes []Change
+	}{
+		{"a\nb\nc", Command{Op: OpDelete, Address: Address{Start: LineAddress{Kind: AddressLine, Line: 2}}}, "a\nc", []Change{{2, 2, "b\n"}}},
+		{"a\nb\nc\n", Command{Op: OpDelete, Address: Address{LineAddress{Kind: AddressPattern, Pattern: "b"}, LineAddress{Kind: AddressLast}}}, "a\n", []Change{{2, 2, "b\nc\n"}}},
+		{"a\nb", Command{Op: OpAppend, Address: Address{Start: LineAddress{Kind: AddressLast}}, ReplaceWith: "c"}, "a\nb\nc", []Change{{3, 5, ""}}},
+		{"a\nb", Command{Op: OpAppend, ReplaceWith: "-"}, "a\n-\nb\n-", []Change{{1, 3, ""}, {5, 7, ""}}},
+		{"a\nb", Command{Op: OpInsert, Address: Address{Start: LineAddress{Kind: AddressLine, Line: 2}}, ReplaceWith: "x\ny"}, "a\nx\ny\nb", []Change{{2, 6, ""}}},
+		{"a\nb\nc\nd", Command{Op: OpChange, Address: Address{LineAddress{Kind: AddressLine, Line: 2}, LineAddress{Kind: AddressLine, Line: 3}}, ReplaceWith: "x"}, "a\nx\nd", []Change{{2, 3, "b\nc"}}},
+		{"a\nb\na", Command{Op: OpChange, Address: Address{Start: LineAddress{Kind: AddressPattern, Pattern: "a"}}, ReplaceWith: "x"}, "x\nb\nx", []Change{{0, 1, "a"}, {4, 5, "a"}}},
+	}
+
+	for _, c := range cases {
+		result, err := c.cmd.Apply(c.in)
+		if err != nil {
+			t.Errorf("%+v.Apply(%q) should not have errored but did: %s", c.cmd, c.in, err)
+			continue
+		}
+
+		if result.Output != c.out || !reflect.DeepEqual(result.Changes, c.changes) {
+			t.Errorf("%+v.Apply(%q) should have returned (%q, %+v) but returned (%q, %+v)", c.cmd, c.in, c.out, c.changes, result.Output, result.Changes)
+		}
+	}
+}
+
+func TestCommandEditLinesErrors(t *testing.T) {
+	deleteMissing := Command{Op: OpDelete, Address: Address{Start: LineAddress{Kind: AddressLine, Line: 5}}}
+	if _, err := deleteMissing.Apply("a\nb"); !errors.Is(err, ErrNoChange) {
+		t.Errorf("%+v.Apply should have returned ErrNoChange but returned %v", deleteMissing, err)
+	}
+
+	appendMany := Command{Op: OpAppend, ReplaceWith: "0123456789"}
+	var outputErr *OutputTooLongError
+	if _, err := appendMany.ApplyWithLimits("a\nb\nc", &Limits{MaxOutputLength: 20}); !errors.As(err, &outputErr) {
+		t.Errorf("%+v.ApplyWithLimits should have returned an *OutputTooLongError but returned %v", appendMany, err)
+	}
+}
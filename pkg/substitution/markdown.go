@@ -0,0 +1,256 @@
+package substitution
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// markdownNodeKind categorizes a span of a markdown body
+type markdownNodeKind int
+
+const (
+	markdownText   markdownNodeKind = iota // plain text (including the alt text of images & HTML, which Reddit shows as is)
+	markdownSyntax                         // markup that is never substituted (emphasis, quote markers, fences & brackets)
+	markdownCode                           // the content of inline code & code blocks
+	markdownLink                           // link destinations, reference labels & bare URLs
+)
+
+// markdownNode is a contiguous span of a markdown body
+type markdownNode struct {
+	kind  markdownNodeKind
+	start int
+	end   int
+}
+
+// markdownLocator finds where the nodes of a blackfriday AST came from in the body it was parsed from. blackfriday
+// doesn't keep source offsets, so the literal of each node is looked up in the body following the previous node
+// (nodes are visited in the order they were written). Whatever lies between them is markup.
+type markdownLocator struct {
+	body  string
+	nodes []markdownNode
+	pos   int
+	links []int // for each link being visited, the index of its first node (-1 for bare URLs & autolinks)
+}
+
+// linkDefinitionRe matches a link definition ([label]: destination), which blackfriday leaves out of its AST
+var linkDefinitionRe = regexp.MustCompile(`(?m)^ {0,3}\[[^\]\n]+\]:[ \t]*<?([^\s<>]+)`)
+
+// push records body[start:end] as a node of kind, merging it with a neighbor of the same kind
+func (l *markdownLocator) push(kind markdownNodeKind, start int, end int) {
+	if end <= start {
+		return
+	}
+
+	if n := len(l.nodes); n > 0 && l.nodes[n-1].kind == kind && l.nodes[n-1].end == start {
+		l.nodes[n-1].end = end
+	} else {
+		l.nodes = append(l.nodes, markdownNode{kind, start, end})
+	}
+	l.pos = end
+}
+
+// add records body[start:end] as a node of kind. The gap since the previous node is syntax, apart from the
+// destinations of any link definitions in it.
+func (l *markdownLocator) add(kind markdownNodeKind, start int, end int) {
+	gap := l.pos
+	for _, match := range linkDefinitionRe.FindAllStringSubmatchIndex(l.body[gap:start], -1) {
+		if gap+match[0] > 0 && l.body[gap+match[0]-1] != '\n' {
+			continue
+		}
+		l.push(markdownSyntax, l.pos, gap+match[2])
+		l.push(markdownLink, gap+match[2], gap+match[3])
+	}
+	l.push(markdownSyntax, l.pos, start)
+	l.push(kind, start, end)
+}
+
+// locate adds the lines of literal as nodes of kind. blackfriday strips quote markers & indentation from the lines
+// of a block, so each line is looked up by itself, with the line break following it belonging to the node. Lines
+// that aren't found as written (like decoded entities) are left as syntax.
+func (l *markdownLocator) locate(kind markdownNodeKind, literal string) {
+	joined := false
+	for i, line := range strings.Split(literal, "\n") {
+		if i > 0 {
+			joined = joined && strings.HasPrefix(l.body[l.pos:], "\n")
+			if joined {
+				l.add(kind, l.pos, l.pos+1)
+			}
+		}
+		if len(line) == 0 {
+			continue
+		}
+
+		j := strings.Index(l.body[l.pos:], line)
+		joined = j >= 0
+		if joined {
+			l.add(kind, l.pos+j, l.pos+j+len(line))
+		}
+	}
+}
+
+// skipFence moves past the opening fence line (including its info string) of a fenced code block
+func (l *markdownLocator) skipFence(length int) {
+	i := -1
+	for _, fence := range []string{strings.Repeat("`", length), strings.Repeat("~", length)} {
+		if j := strings.Index(l.body[l.pos:], fence); j >= 0 && (i < 0 || j < i) {
+			i = j
+		}
+	}
+	if i < 0 {
+		return
+	}
+
+	end := len(l.body)
+	if j := strings.IndexByte(l.body[l.pos+i:], '\n'); j >= 0 {
+		end = l.pos + i + j
+	}
+	l.add(markdownSyntax, l.pos, end)
+}
+
+// isAutolink reports whether node is a bare URL or an autolink (<url>), whose text is the URL itself, rather than
+// [text](destination)
+func (l *markdownLocator) isAutolink(node *blackfriday.Node) bool {
+	child := node.FirstChild
+	if node.Type != blackfriday.Link || child == nil || child != node.LastChild || child.Type != blackfriday.Text {
+		return false
+	}
+
+	i := strings.Index(l.body[l.pos:], string(child.Literal))
+	return i >= 0 && !strings.Contains(l.body[l.pos:l.pos+i], "[")
+}
+
+// isSpace matches the whitespace blackfriday allows around link destinations
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '\v'
+}
+
+// locateTarget adds what the link (or image) whose text was just located points to: the destination of
+// [text](destination), the label of [text][label] or otherwise the text itself, since that's the label of [label] &
+// [label][] and changing it would break the link. first is the index of the first node of the text.
+func (l *markdownLocator) locateTarget(node *blackfriday.Node, first int) {
+	closing := strings.IndexByte(l.body[l.pos:], ']')
+	if closing < 0 {
+		return
+	}
+	rest := strings.TrimLeftFunc(l.body[l.pos+closing+1:], isSpace)
+	start := len(l.body) - len(rest)
+
+	switch {
+	case strings.HasPrefix(rest, "("):
+		destination := strings.TrimLeftFunc(rest[1:], isSpace)
+		destination = strings.TrimPrefix(destination, "<")
+		start = len(l.body) - len(destination)
+		if len(node.Destination) > 0 && strings.HasPrefix(destination, string(node.Destination)) {
+			l.add(markdownLink, start, start+len(node.Destination))
+		}
+	case strings.HasPrefix(rest, "[") && !strings.HasPrefix(rest, "[]"):
+		if end := strings.IndexByte(rest, ']'); end >= 0 {
+			l.add(markdownLink, start+1, start+end)
+		}
+	default:
+		for i := first; i < len(l.nodes); i++ {
+			if l.nodes[i].kind == markdownText {
+				l.nodes[i].kind = markdownLink
+			}
+		}
+	}
+}
+
+func (l *markdownLocator) visit(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+	switch node.Type {
+	case blackfriday.Text, blackfriday.HTMLSpan, blackfriday.HTMLBlock:
+		l.locate(markdownText, string(node.Literal))
+	case blackfriday.Code:
+		l.locate(markdownCode, string(node.Literal))
+	case blackfriday.CodeBlock:
+		if node.IsFenced {
+			l.skipFence(node.FenceLength)
+		}
+		l.locate(markdownCode, string(node.Literal))
+	case blackfriday.Link, blackfriday.Image:
+		if entering {
+			if l.isAutolink(node) {
+				l.links = append(l.links, -1)
+				l.locate(markdownLink, string(node.FirstChild.Literal))
+				return blackfriday.SkipChildren
+			}
+			l.links = append(l.links, len(l.nodes))
+			break
+		}
+
+		first := l.links[len(l.links)-1]
+		l.links = l.links[:len(l.links)-1]
+		if first >= 0 {
+			l.locateTarget(node, first)
+		}
+	}
+
+	return blackfriday.GoToNext
+}
+
+// parseMarkdownNodes splits a Reddit markdown body into contiguous nodes, parsing it with blackfriday (the same way
+// persistence.Reply.RenderMarkdown does). Nodes are kept as offsets into the body so that a substitution confined to
+// text nodes leaves the rest of the markdown exactly as it was written.
+func parseMarkdownNodes(body string) []markdownNode {
+	l := markdownLocator{body: body}
+	parser := blackfriday.New(blackfriday.WithExtensions(blackfriday.CommonExtensions))
+	parser.Parse([]byte(body)).Walk(l.visit)
+	l.add(markdownSyntax, len(body), len(body))
+
+	return l.nodes
+}
+
+// markdownScope decides which kinds of nodes may be changed by a substitution
+type markdownScope struct {
+	nodes        []markdownNode
+	includeCode  bool
+	includeLinks bool
+}
+
+func (m *markdownScope) editable(kind markdownNodeKind) bool {
+	switch kind {
+	case markdownText:
+		return true
+	case markdownCode:
+		return m.includeCode
+	case markdownLink:
+		return m.includeLinks
+	default:
+		return false
+	}
+}
+
+// allows reports whether a match of body[start:end] only touches editable nodes. An empty match must sit within
+// or next to an editable node.
+func (m *markdownScope) allows(start int, end int) bool {
+	if start == end {
+		for _, node := range m.nodes {
+			if node.start <= start && start <= node.end && m.editable(node.kind) {
+				return true
+			}
+		}
+		return len(m.nodes) == 0
+	}
+
+	for _, node := range m.nodes {
+		if node.start < end && node.end > start && !m.editable(node.kind) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filter drops the matches that would change anything other than editable nodes
+func (m *markdownScope) filter(matches [][]int) [][]int {
+	filtered := make([][]int, 0, len(matches))
+	for _, match := range matches {
+		if m.allows(match[0], match[1]) {
+			filtered = append(filtered, match)
+		}
+	}
+
+	return filtered
+}
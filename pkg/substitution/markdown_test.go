@@ -0,0 +1,84 @@
+package substitution
+
+import (
+	"strings"
+	"testing"
+)
+
+// describeMarkdownNodes renders nodes as kind:"text" pairs for easy comparison
+func describeMarkdownNodes(body string, nodes []markdownNode) string {
+	names := map[markdownNodeKind]string{markdownText: "text", markdownSyntax: "syntax", markdownCode: "code", markdownLink: "link"}
+
+	parts := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		parts = append(parts, names[node.kind]+":"+body[node.start:node.end])
+	}
+
+	return strings.Join(parts, "|")
+}
+
+func TestParseMarkdownNodes(t *testing.T) {
+	cases := []struct {
+		body  string
+		nodes string
+	}{
+		{"", ""},
+		{"plain *text*", "text:plain |syntax:*|text:text|syntax:*"},
+		{"# Title\n\na &amp; b", "syntax:# |text:Title|syntax:\n\n|text:a &|syntax:amp;|text: b"},
+		{"use `x := 1` here", "text:use |syntax:`|code:x := 1|syntax:`|text: here"},
+		{"``a ` b`` c", "syntax:``|code:a ` b|syntax:``|text: c"},
+		{"unclosed ` tick", "text:unclosed ` tick"},
+		{"see [the docs](https://a.b/c) now", "text:see |syntax:[|text:the docs|syntax:](|link:https://a.b/c|syntax:)|text: now"},
+		{"[a `b`](c)", "syntax:[|text:a |syntax:`|code:b|syntax:`](|link:c|syntax:)"},
+		{"[**a**](<c> \"title\")", "syntax:[**|text:a|syntax:**](<|link:c|syntax:> \"title\")"},
+		{"go to https://x.y/z. ok", "text:go to |link:https://x.y/z|text:. ok"},
+		{"[https://x.y](https://z)", "syntax:[|text:https://x.y|syntax:](|link:https://z|syntax:)"},
+		{"an <https://x.y> autolink", "text:an |syntax:<|link:https://x.y|syntax:>|text: autolink"},
+		{"> quoted\n> more", "syntax:> |text:quoted\n|syntax:> |text:more"},
+		{"a\n```go\ncode\n\nmore\n```\nb", "text:a|syntax:\n```go\n|code:code\n\nmore\n|syntax:```\n|text:b"},
+		{"a\n\n    code\n    more\nb", "text:a|syntax:\n\n    |code:code\n|syntax:    |code:more\n|text:b"},
+		{"[ref]: https://x.y", "syntax:[ref]: |link:https://x.y"},
+		{`\[a](b)`, `syntax:\|text:[a](b)`},
+		{"see [it][ref] ok\n\n[Ref]: https://x.y", "text:see |syntax:[|text:it|syntax:][|link:ref|syntax:]|text: ok|syntax:\n\n[Ref]: |link:https://x.y"},
+		{"[ref] & [ref][]\n[ref]: https://x.y", "syntax:[|link:ref|syntax:]|text: & |syntax:[|link:ref|syntax:][]\n[ref]: |link:https://x.y"},
+		{"[it][undefined]", "text:[it][undefined]"},
+		{"[![alt](a.png)](https://x.y)", "syntax:[![|text:alt|syntax:](|link:a.png|syntax:)](|link:https://x.y|syntax:)"},
+		{"- item\n\n        code\n- b", "syntax:- |text:item|syntax:\n\n        |code:code\n|syntax:- |text:b"},
+		{"1. item\n\n    more\nb", "syntax:1. |text:item|syntax:\n\n    |text:more\nb"},
+		{"| a | b |\n|---|---|\n| c | d |", "syntax:| |text:a|syntax: | |text:b|syntax: |\n|---|---|\n| |text:c|syntax: | |text:d|syntax: |"},
+		{"a <b>c</b>", "text:a <b>c</b>"},
+	}
+
+	for _, c := range cases {
+		out := describeMarkdownNodes(c.body, parseMarkdownNodes(c.body))
+		if out != c.nodes {
+			t.Errorf("parseMarkdownNodes(%q) should have returned %q but returned %q", c.body, c.nodes, out)
+		}
+	}
+}
+
+func TestMarkdownScopeAllows(t *testing.T) {
+	body := "ab `cd` ef"
+	cases := []struct {
+		start       int
+		end         int
+		includeCode bool
+		allowed     bool
+	}{
+		{0, 2, false, true},
+		{4, 6, false, false},
+		{4, 6, true, true},
+		{0, 4, true, false}, // The backtick is never substituted
+		{3, 3, false, true}, // Empty match right before the code span
+		{5, 5, false, false},
+		{5, 5, true, true},
+		{10, 10, false, true},
+	}
+
+	for _, c := range cases {
+		scope := markdownScope{parseMarkdownNodes(body), c.includeCode, false}
+		if scope.allows(c.start, c.end) != c.allowed {
+			t.Errorf("markdownScope{includeCode: %t}.allows(%d, %d) on %q should have returned %t", c.includeCode, c.start, c.end, body, c.allowed)
+		}
+	}
+}
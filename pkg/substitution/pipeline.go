@@ -0,0 +1,174 @@
+package substitution
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMaxStages is the number of commands a Pipeline may hold when no cap is given to ParsePipeline
+const DefaultMaxStages = 5
+
+// MaxScriptLength caps how many characters the commands of a Pipeline may take up
+const MaxScriptLength = 2000
+
+// Pipeline represents a sequence of Commands that are applied one after another
+type Pipeline []Command
+
+// StageError is returned when a specific stage (1-indexed) of a Pipeline fails to parse or run
+type StageError struct {
+	Stage int
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("stage %d: %s", e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// TooManyStagesError is returned when a comment holds more commands than allowed
+type TooManyStagesError struct {
+	Max int
+}
+
+func (e *TooManyStagesError) Error() string {
+	return fmt.Sprintf("more than %d commands given", e.Max)
+}
+
+// ScriptTooLongError is returned when the commands of a comment take up more characters than allowed
+type ScriptTooLongError struct {
+	Max int
+}
+
+func (e *ScriptTooLongError) Error() string {
+	return fmt.Sprintf("commands are more than %d characters long", e.Max)
+}
+
+// ParsePipeline tries to parse one or more VIM style substitution (or sed style transliteration or line) commands
+// from a string, like a small sed script. Commands are given one per line or separated by a ; directly following a
+// closing delimiter (or a d command) & the first command must begin the string. Parsing stops at the first line that
+// doesn't begin with a command. maxStages caps the number of commands (DefaultMaxStages when nil) & together they
+// may be at most MaxScriptLength characters long.
+func ParsePipeline(txt string, maxStages *int) (Pipeline, error) {
+	return parsePipeline(tokenizer{txt: txt}, maxStages)
+}
+
+// parsePipeline parses a Pipeline whose first command begins at the tokenizer's position
+func parsePipeline(t tokenizer, maxStages *int) (Pipeline, error) {
+	if maxStages == nil {
+		defaultMaxStages := DefaultMaxStages
+		maxStages = &defaultMaxStages
+	}
+
+	txt, start := t.txt, t.pos
+	pipeline := Pipeline{}
+	for t.pos < len(txt) {
+		if len(pipeline) > 0 && !t.atCommand() {
+			break
+		}
+
+		if len(pipeline) == *maxStages {
+			return nil, &TooManyStagesError{*maxStages}
+		}
+
+		cmd, closed, err := t.scanCommand()
+		if err != nil {
+			if len(pipeline) == 0 {
+				return nil, err
+			}
+			return nil, &StageError{len(pipeline) + 1, err}
+		}
+		pipeline = append(pipeline, *cmd)
+
+		if utf8.RuneCountInString(txt[start:t.pos]) > MaxScriptLength {
+			return nil, &ScriptTooLongError{MaxScriptLength}
+		}
+
+		if closed && strings.HasPrefix(txt[t.pos:], ";") {
+			t.pos++
+			// Allow a trailing ; after the last command
+			if !t.atLineEnd() {
+				if !t.atCommand() {
+					return nil, &StageError{len(pipeline) + 1, &ParseError{Pos: t.pos, Reason: "expected a command after ;"}}
+				}
+				continue
+			}
+		}
+
+		// Move on to the next non blank line
+		t.skipLine()
+		for t.pos < len(txt) && len(strings.TrimSpace(t.restOfLine())) == 0 {
+			t.skipLine()
+		}
+	}
+
+	if len(pipeline) == 0 {
+		return nil, ErrNotCommand
+	}
+
+	return pipeline, nil
+}
+
+// Run executes each Command of the Pipeline in order, feeding the output of one into the next. Stages that change
+// nothing are skipped, but the Pipeline as a whole must change the given string (or count or explain matches).
+func (p Pipeline) Run(txt string) (string, error) {
+	result, err := p.Apply(txt)
+	if err != nil {
+		return "", err
+	}
+
+	return result.Output, nil
+}
+
+// Apply executes the Pipeline like Run, returning the final output along with the spans that were changed with
+// respect to the given string.
+func (p Pipeline) Apply(txt string) (*Result, error) {
+	return p.ApplyWithLimits(txt, nil)
+}
+
+// ApplyWithLimits executes the Pipeline like Apply, holding every stage to the given limits (DefaultLimits when nil)
+func (p Pipeline) ApplyWithLimits(txt string, limits *Limits) (*Result, error) {
+	if limits == nil {
+		limits = &DefaultLimits
+	}
+
+	result := &Result{Output: txt}
+	for i := range p {
+		stage, err := p[i].ApplyWithLimits(result.Output, limits)
+		if errors.Is(err, ErrNoChange) {
+			continue
+		}
+
+		if err != nil {
+			return nil, &StageError{i + 1, err}
+		}
+
+		if len(stage.Explanation) > 0 {
+			if len(result.Explanation) > 0 {
+				result.Explanation += "\n\n"
+			}
+			result.Explanation += stage.Explanation
+			continue
+		}
+
+		if stage.Counted {
+			result.Counted = true
+			result.Matches = append(result.Matches, stage.Matches...)
+			continue
+		}
+
+		counted, matches, explanation := result.Counted, result.Matches, result.Explanation
+		result = result.then(stage)
+		result.Counted, result.Matches, result.Explanation = counted, matches, explanation
+	}
+
+	if result.Output == txt && !result.Counted && len(result.Explanation) == 0 {
+		return nil, ErrNoChange
+	}
+
+	return result, nil
+}
@@ -0,0 +1,187 @@
+package substitution
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestParsePipeline(t *testing.T) {
+	cases := []struct {
+		input     string
+		maxStages *int
+		pipeline  Pipeline
+		err       bool
+		errStage  int
+	}{
+		{"", nil, nil, true, 0},
+		{"not a command", nil, nil, true, 0},
+		{"s/a/b/", nil, Pipeline{{ToReplace: "a", ReplaceWith: "b"}}, false, 0},
+		{"s/a/b/;", nil, Pipeline{{ToReplace: "a", ReplaceWith: "b"}}, false, 0},
+		{"s/a/b;c/", nil, Pipeline{{ToReplace: "a", ReplaceWith: "b;c"}}, false, 0}, // ; inside an unclosed replacement
+		{
+			"s/a/b/;s/c/d/g",
+			nil,
+			Pipeline{{ToReplace: "a", ReplaceWith: "b"}, {ToReplace: "c", ReplaceWith: "d", Flags: Flags{Global: true}}},
+			false,
+			0,
+		},
+		{
+			"s/a/b/ ; s#c#d#",
+			nil,
+			nil,
+			true, // Commands must start right after the ;
+			2,
+		},
+		{
+			"s/a/b/\ns#c#d#\n\ns/e/f\nsome trailing text\ns/g/h/",
+			nil,
+			Pipeline{{ToReplace: "a", ReplaceWith: "b"}, {ToReplace: "c", ReplaceWith: "d"}, {ToReplace: "e", ReplaceWith: "f"}},
+			false,
+			0,
+		},
+		{"s/a/b/\ns/c/d/x", nil, nil, true, 2},
+		{
+			"2s/a/b/;%s/c/d/",
+			nil,
+			Pipeline{
+				{Address: Address{Start: LineAddress{Kind: AddressLine, Line: 2}}, ToReplace: "a", ReplaceWith: "b"},
+				{Address: Address{LineAddress{Kind: AddressLine, Line: 1}, LineAddress{Kind: AddressLast}}, ToReplace: "c", ReplaceWith: "d"},
+			},
+			false,
+			0,
+		},
+		{
+			"2d;/x/,$c\\done; for now\ns/a/b/g\n1i\\\nfirst\\\nsecond",
+			nil,
+			Pipeline{
+				{Op: OpDelete, Address: Address{Start: LineAddress{Kind: AddressLine, Line: 2}}},
+				{Op: OpChange, Address: Address{LineAddress{Kind: AddressPattern, Pattern: "x"}, LineAddress{Kind: AddressLast}}, ReplaceWith: "done; for now"},
+				{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Global: true}},
+				{Op: OpInsert, Address: Address{Start: LineAddress{Kind: AddressLine, Line: 1}}, ReplaceWith: "first\nsecond"},
+			},
+			false,
+			0,
+		},
+		{"s/a/b/;d", nil, nil, true, 2}, // d has to be addressed
+		{"s/a/b/;s/c/d/;s/e/f/", intPtr(2), nil, true, 0},
+		{"s/a/b/\ns/c/d/", intPtr(2), Pipeline{{ToReplace: "a", ReplaceWith: "b"}, {ToReplace: "c", ReplaceWith: "d"}}, false, 0},
+	}
+
+	for _, c := range cases {
+		out, err := ParsePipeline(c.input, c.maxStages)
+		if c.err {
+			if err == nil {
+				t.Errorf("ParsePipeline(%q) should have errored but did not", c.input)
+			}
+
+			var stageErr *StageError
+			if c.errStage > 0 && (!errors.As(err, &stageErr) || stageErr.Stage != c.errStage) {
+				t.Errorf("ParsePipeline(%q) should have errored in stage %d but returned %v", c.input, c.errStage, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParsePipeline(%q) should not have errored but did: %s", c.input, err)
+			continue
+		}
+
+		if len(out) != len(c.pipeline) {
+			t.Errorf("ParsePipeline(%q) should have returned %d commands but returned %d", c.input, len(c.pipeline), len(out))
+			continue
+		}
+
+		for i := range out {
+			if out[i] != c.pipeline[i] {
+				t.Errorf("ParsePipeline(%q) stage %d should have been %+v but was %+v", c.input, i+1, c.pipeline[i], out[i])
+			}
+		}
+	}
+}
+
+func TestParsePipelineScriptTooLong(t *testing.T) {
+	script := strings.Repeat("1a\\"+strings.Repeat("x", MaxScriptLength/2)+"\n", 3)
+	var scriptErr *ScriptTooLongError
+	if _, err := ParsePipeline(script, nil); !errors.As(err, &scriptErr) {
+		t.Errorf("ParsePipeline should have returned a *ScriptTooLongError for a %d character script but returned %v", len(script), err)
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	cases := []struct {
+		in       string
+		pipeline Pipeline
+		out      string
+		err      bool
+		errStage int
+	}{
+		{"abc", Pipeline{{ToReplace: "a", ReplaceWith: "x"}, {ToReplace: "x", ReplaceWith: "y"}}, "ybc", false, 0},
+		{"abc", Pipeline{{ToReplace: "z", ReplaceWith: "x"}, {ToReplace: "c", ReplaceWith: "d"}}, "abd", false, 0}, // Stage without matches
+		{"abc", Pipeline{{ToReplace: "z", ReplaceWith: "x"}, {ToReplace: "q", ReplaceWith: "d"}}, "", true, 0},
+		{"abc", Pipeline{{ToReplace: "a", ReplaceWith: "b"}, {ToReplace: "b", ReplaceWith: "a"}}, "", true, 0}, // Net change is nothing
+		{"abc", Pipeline{{ToReplace: "a", ReplaceWith: "x"}, {ToReplace: "(", ReplaceWith: "y", Flags: Flags{Mode: ModeGo}}}, "", true, 2},
+	}
+
+	for _, c := range cases {
+		out, err := c.pipeline.Run(c.in)
+		if c.err && err == nil {
+			t.Errorf("%+v.Run(%s) should have errored but did not", c.pipeline, c.in)
+		}
+
+		if !c.err && err != nil {
+			t.Errorf("%+v.Run(%s) should not have errored but did: %s", c.pipeline, c.in, err)
+		}
+
+		var stageErr *StageError
+		if c.errStage > 0 && (!errors.As(err, &stageErr) || stageErr.Stage != c.errStage) {
+			t.Errorf("%+v.Run(%s) should have errored in stage %d but returned %v", c.pipeline, c.in, c.errStage, err)
+		}
+
+		if c.out != out {
+			t.Errorf("%+v.Run(%s) should have returned %s but returned %s", c.pipeline, c.in, c.out, out)
+		}
+	}
+}
+
+func TestPipelineCount(t *testing.T) {
+	pipeline := Pipeline{{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Global: true}}, {ToReplace: "b", Flags: Flags{Global: true, CountOnly: true}}}
+
+	result, err := pipeline.Apply("ab")
+	if err != nil {
+		t.Errorf("%+v.Apply(ab) should not have errored but did: %s", pipeline, err)
+		return
+	}
+
+	if result.Output != "bb" || len(result.Changes) != 1 || !result.Counted || len(result.Matches) != 2 {
+		t.Errorf("%+v.Apply(ab) should have changed ab to bb & counted 2 matches but returned %+v", pipeline, *result)
+	}
+
+	// Counting alone doesn't need to change anything
+	if _, err := pipeline[1:].Apply("ab"); err != nil {
+		t.Errorf("%+v.Apply(ab) should not have errored but did: %s", pipeline[1:], err)
+	}
+}
+
+func TestPipelineExplain(t *testing.T) {
+	pipeline := Pipeline{{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Global: true}}, {ToReplace: "b", Flags: Flags{Explain: true}}, {ToReplace: "c", Flags: Flags{Explain: true}}}
+
+	result, err := pipeline.Apply("ab")
+	if err != nil {
+		t.Errorf("%+v.Apply(ab) should not have errored but did: %s", pipeline, err)
+		return
+	}
+
+	if result.Output != "bb" || len(result.Changes) != 1 || strings.Count(result.Explanation, "**Pattern**") != 2 {
+		t.Errorf("%+v.Apply(ab) should have changed ab to bb & explained 2 patterns but returned %+v", pipeline, *result)
+	}
+
+	// Explaining alone doesn't need to change anything
+	if _, err := pipeline[2:].Apply("ab"); err != nil {
+		t.Errorf("%+v.Apply(ab) should not have errored but did: %s", pipeline[2:], err)
+	}
+}
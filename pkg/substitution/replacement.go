@@ -0,0 +1,163 @@
+package substitution
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Mode represents the syntax a Command is written in
+type Mode int
+
+const (
+	// ModeVim uses VIM/sed syntax (\1, & & case modifiers in the replacement)
+	ModeVim Mode = iota
+	// ModeGo uses Go's regexp syntax ($1 & ${name} in the replacement)
+	ModeGo
+	// ModeLiteral matches the pattern as plain text & inserts the replacement as is
+	ModeLiteral
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeVim:
+		return "vim"
+	case ModeGo:
+		return "go"
+	case ModeLiteral:
+		return "literal"
+	default:
+		return "unknown"
+	}
+}
+
+type caseConversion int
+
+const (
+	caseNone caseConversion = iota
+	caseUpper
+	caseLower
+)
+
+func (c caseConversion) apply(s string) string {
+	switch c {
+	case caseUpper:
+		return strings.ToUpper(s)
+	case caseLower:
+		return strings.ToLower(s)
+	default:
+		return s
+	}
+}
+
+// replacementSegment is a run of the replacement that shares the same case conversion
+type replacementSegment struct {
+	template  string         // template in Go's regexp.Expand syntax
+	all       caseConversion // \U or \L - applies to the whole segment
+	firstRune caseConversion // \u or \l - applies to the next character only
+}
+
+// Replacement is a compiled replacement template
+type Replacement struct {
+	segments []replacementSegment
+}
+
+// CompileReplacement compiles a replacement template written in the given Mode. In ModeVim, \0-\9 & & refer to
+// groups (\& being a literal &), \u \l change the case of the next character, \U \L change the case of everything
+// up to \E (or \e) & any other escaped character is taken literally. A $ is always literal. In ModeGo the template
+// is left for regexp.Expand to handle & in ModeLiteral the template is inserted as is.
+func CompileReplacement(template string, mode Mode) *Replacement {
+	switch mode {
+	case ModeGo:
+		return &Replacement{[]replacementSegment{{template: template}}}
+	case ModeLiteral:
+		return &Replacement{[]replacementSegment{{template: strings.ReplaceAll(template, "$", "$$")}}}
+	}
+
+	r := &Replacement{}
+	current := replacementSegment{}
+	builder := strings.Builder{}
+
+	// A pending \u or \l carries over to the next segment if nothing has been written since
+	pendingFirstRune := func() caseConversion {
+		if builder.Len() == 0 {
+			return current.firstRune
+		}
+		return caseNone
+	}
+
+	// Starts a new segment when the case conversion changes
+	startSegment := func(all caseConversion, firstRune caseConversion) {
+		current.template = builder.String()
+		r.segments = append(r.segments, current)
+		builder.Reset()
+		current = replacementSegment{all: all, firstRune: firstRune}
+	}
+
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case c == '$':
+			builder.WriteString("$$")
+		case c == '&':
+			builder.WriteString("${0}")
+		case c == '\\' && i+1 < len(template):
+			i++
+			switch e := template[i]; {
+			case e >= '0' && e <= '9':
+				builder.WriteString("${" + strconv.Itoa(int(e-'0')) + "}")
+			case e == 'n':
+				builder.WriteByte('\n')
+			case e == 't':
+				builder.WriteByte('\t')
+			case e == 'u':
+				startSegment(current.all, caseUpper)
+			case e == 'l':
+				startSegment(current.all, caseLower)
+			case e == 'U':
+				startSegment(caseUpper, pendingFirstRune())
+			case e == 'L':
+				startSegment(caseLower, pendingFirstRune())
+			case e == 'E' || e == 'e':
+				startSegment(caseNone, pendingFirstRune())
+			case e == '$':
+				builder.WriteString("$$")
+			default:
+				builder.WriteByte(e)
+			}
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	startSegment(caseNone, caseNone)
+
+	return r
+}
+
+// Expand appends the replacement for a match (as returned by re.FindStringSubmatchIndex on src) to dst
+func (r *Replacement) Expand(dst []byte, re Expander, src string, match []int) []byte {
+	pending := caseNone
+	for _, segment := range r.segments {
+		expanded := segment.all.apply(string(re.ExpandString(nil, segment.template, src, match)))
+
+		if segment.firstRune != caseNone {
+			pending = segment.firstRune
+		}
+
+		if pending != caseNone && len(expanded) > 0 {
+			first, size := utf8.DecodeRuneInString(expanded)
+			if pending == caseUpper {
+				first = unicode.ToUpper(first)
+			} else {
+				first = unicode.ToLower(first)
+			}
+			expanded = string(first) + expanded[size:]
+			pending = caseNone
+		}
+
+		dst = append(dst, expanded...)
+	}
+
+	return dst
+}
@@ -0,0 +1,45 @@
+package substitution
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestReplacementExpand(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		template string
+		mode     Mode
+		in       string
+		out      string
+	}{
+		{`(\w+) (\w+)`, `\2 \1`, ModeVim, "hello world", "world hello"},
+		{`(\w+) (\w+)`, `\0!`, ModeVim, "hello world", "hello world!"},
+		{`\w+`, `<&>`, ModeVim, "hello", "<hello>"},
+		{`\w+`, `\&`, ModeVim, "hello", "&"},
+		{`\w+`, `$5 ${0}`, ModeVim, "hello", "$5 ${0}"},
+		{`\w+`, `a\\b\$`, ModeVim, "hello", `a\b$`},
+		{`\w+`, `a\nb\tc`, ModeVim, "hello", "a\nb\tc"},
+		{`\w+`, `\/\x`, ModeVim, "hello", "/x"},
+		{`\w+`, `trailing\`, ModeVim, "hello", `trailing\`},
+		{`(\w+) (\w+)`, `\u\1 \U\2\E!`, ModeVim, "hello world", "Hello WORLD!"},
+		{`(\w+) (\w+)`, `\U\1 \l\2`, ModeVim, "hello world", "HELLO wORLD"},
+		{`(\w+)`, `\L\u\1`, ModeVim, "hELLO", "Hello"},
+		{`(\w+)`, `\u\L\1`, ModeVim, "hELLO", "Hello"},
+		{`(\w+)`, `\uabc\Ldef`, ModeVim, "x", "Abcdef"},
+		{`(\w*)x`, `\u\1y`, ModeVim, "x", "Y"}, // \u carries past an empty group
+		{`(\pL+)`, `\U\1\e \1`, ModeVim, "ünï", "ÜNÏ ünï"},
+		{`(?P<word>\w+)`, `${word}$1$$`, ModeGo, "hello", "hellohello$"},
+		{`(\w+)`, `\1&`, ModeGo, "hello", `\1&`},
+		{`(\w+)`, `\1&$1${1}\u`, ModeLiteral, "hello", `\1&$1${1}\u`},
+	}
+
+	for _, c := range cases {
+		re := regexp.MustCompile(c.pattern)
+		match := re.FindStringSubmatchIndex(c.in)
+		out := string(CompileReplacement(c.template, c.mode).Expand(nil, re, c.in, match))
+		if out != c.out {
+			t.Errorf("CompileReplacement(%s, %s).Expand(%s) should have returned %q but returned %q", c.template, c.mode, c.in, c.out, out)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package substitution
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Change is a span of the output of a substitution that replaced Old in the input
+type Change struct {
+	Start int // byte offset of the new text in the output
+	End   int
+	Old   string
+}
+
+// Match is a span of the input found by a count only command
+type Match struct {
+	Start   int
+	End     int
+	Excerpt string // the line(s) holding the match, shortened around it
+}
+
+// Result holds the output of a substitution along with the spans it changed (sorted & non overlapping). When a count
+// only command ran, Counted is set & Matches holds what it found (offsets are into the input of that command). An
+// explained command leaves its markdown description in Explanation.
+type Result struct {
+	Output      string
+	Changes     []Change
+	Counted     bool
+	Matches     []Match
+	Explanation string
+}
+
+// excerptContext is how many bytes either side of a match are kept in its excerpt
+const excerptContext = 40
+
+// excerpt returns the line(s) of txt holding txt[start:end], cut down to excerptContext either side of it
+func excerpt(txt string, start int, end int) string {
+	lineStart := strings.LastIndexByte(txt[:start], '\n') + 1
+	lineEnd := len(txt)
+	if i := strings.IndexByte(txt[end:], '\n'); i >= 0 {
+		lineEnd = end + i
+	}
+
+	prefix, suffix := "", ""
+	if start-lineStart > excerptContext {
+		lineStart = start - excerptContext
+		for !utf8.RuneStart(txt[lineStart]) {
+			lineStart++
+		}
+		prefix = "..."
+	}
+
+	if lineEnd-end > excerptContext {
+		lineEnd = end + excerptContext
+		for !utf8.RuneStart(txt[lineEnd]) {
+			lineEnd--
+		}
+		suffix = "..."
+	}
+
+	return prefix + txt[lineStart:lineEnd] + suffix
+}
+
+// countMatches builds the Result of a count only command from the matches it selected
+func countMatches(txt string, matches [][]int) *Result {
+	result := &Result{Output: txt, Counted: true, Matches: []Match{}}
+	for _, m := range matches {
+		result.Matches = append(result.Matches, Match{m[0], m[1], excerpt(txt, m[0], m[1])})
+	}
+
+	return result
+}
+
+// addChange records a replacement that was just written to the end of the output, merging it with a replacement it
+// directly follows so that highlighting doesn't split contiguous changes.
+func (r *Result) addChange(c Change) {
+	if n := len(r.Changes); n > 0 && r.Changes[n-1].End == c.Start {
+		r.Changes[n-1].End = c.End
+		r.Changes[n-1].Old += c.Old
+		return
+	}
+
+	r.Changes = append(r.Changes, c)
+}
+
+// dropUnchanged removes changes that replaced text with identical text
+func (r *Result) dropUnchanged() {
+	changes := r.Changes[:0]
+	for _, c := range r.Changes {
+		if r.Output[c.Start:c.End] != c.Old {
+			changes = append(changes, c)
+		}
+	}
+	r.Changes = changes
+}
+
+// then composes a Result with a later Result that was produced from its Output. Changes of the two that overlap or
+// touch are merged so every change of the composed Result refers to the original input.
+func (r *Result) then(next *Result) *Result {
+	// Express the later changes in terms of r.Output so both sets can be grouped together
+	type laterChange struct {
+		start  int
+		end    int
+		growth int
+	}
+	later := make([]laterChange, len(next.Changes))
+	shift := 0
+	for i, c := range next.Changes {
+		start := c.Start - shift
+		growth := (c.End - c.Start) - len(c.Old)
+		later[i] = laterChange{start, start + len(c.Old), growth}
+		shift += growth
+	}
+
+	composed := &Result{Output: next.Output}
+	earlier := r.Changes
+	i, j := 0, 0
+	growth := 0 // how much longer next.Output is than r.Output before the current group
+	for i < len(earlier) || j < len(later) {
+		var start, end int
+		if j == len(later) || (i < len(earlier) && earlier[i].Start <= later[j].start) {
+			start, end = earlier[i].Start, earlier[i].End
+		} else {
+			start, end = later[j].start, later[j].end
+		}
+
+		// Map the grouped span of r.Output back to the original input as the group grows
+		old := ""
+		pos := start
+		groupGrowth := 0
+		for {
+			if i < len(earlier) && earlier[i].Start <= end {
+				old += r.Output[pos:earlier[i].Start] + earlier[i].Old
+				pos = earlier[i].End
+				if earlier[i].End > end {
+					end = earlier[i].End
+				}
+				i++
+			} else if j < len(later) && later[j].start <= end {
+				if later[j].end > end {
+					end = later[j].end
+				}
+				groupGrowth += later[j].growth
+				j++
+			} else {
+				break
+			}
+		}
+		old += r.Output[pos:end]
+
+		composed.Changes = append(composed.Changes, Change{start + growth, end + growth + groupGrowth, old})
+		growth += groupGrowth
+	}
+
+	composed.dropUnchanged()
+	return composed
+}
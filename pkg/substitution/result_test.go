@@ -0,0 +1,90 @@
+package substitution
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResultThen(t *testing.T) {
+	cases := []struct {
+		first   Result
+		second  Result
+		changes []Change
+	}{
+		{
+			// "abc" -> "xbc" -> "xbd"
+			Result{Output: "xbc", Changes: []Change{{0, 1, "a"}}},
+			Result{Output: "xbd", Changes: []Change{{2, 3, "c"}}},
+			[]Change{{0, 1, "a"}, {2, 3, "c"}},
+		},
+		{
+			// "abc" -> "xxbc" -> "yybc"
+			Result{Output: "xxbc", Changes: []Change{{0, 2, "a"}}},
+			Result{Output: "yybc", Changes: []Change{{0, 2, "xx"}}},
+			[]Change{{0, 2, "a"}},
+		},
+		{
+			// "abc" -> "axc" -> "ayyc" (later change inside an earlier one)
+			Result{Output: "axc", Changes: []Change{{1, 2, "b"}}},
+			Result{Output: "ayyc", Changes: []Change{{1, 3, "x"}}},
+			[]Change{{1, 3, "b"}},
+		},
+		{
+			// "abcd" -> "Abcd" -> "Xd" (later change spans an earlier one & unchanged text)
+			Result{Output: "Abcd", Changes: []Change{{0, 1, "a"}}},
+			Result{Output: "Xd", Changes: []Change{{0, 1, "Abc"}}},
+			[]Change{{0, 1, "abc"}},
+		},
+		{
+			// "a b" -> "a c" -> "d c" (changes touching across stages stay apart)
+			Result{Output: "a c", Changes: []Change{{2, 3, "b"}}},
+			Result{Output: "d c", Changes: []Change{{0, 1, "a"}}},
+			[]Change{{0, 1, "a"}, {2, 3, "b"}},
+		},
+		{
+			// "abc" -> "bc" -> "c" (deletions next to each other merge)
+			Result{Output: "bc", Changes: []Change{{0, 0, "a"}}},
+			Result{Output: "c", Changes: []Change{{0, 0, "b"}}},
+			[]Change{{0, 0, "ab"}},
+		},
+		{
+			// "abc" -> "xbc" -> "abc" (changes that were undone disappear)
+			Result{Output: "xbc", Changes: []Change{{0, 1, "a"}}},
+			Result{Output: "abc", Changes: []Change{{0, 1, "x"}}},
+			[]Change{},
+		},
+	}
+
+	for _, c := range cases {
+		out := c.first.then(&c.second)
+		if out.Output != c.second.Output {
+			t.Errorf("%+v.then(%+v) should have output %q but had %q", c.first, c.second, c.second.Output, out.Output)
+		}
+
+		if len(out.Changes) != len(c.changes) || (len(c.changes) > 0 && !reflect.DeepEqual(out.Changes, c.changes)) {
+			t.Errorf("%+v.then(%+v) should have changes %+v but had %+v", c.first, c.second, c.changes, out.Changes)
+		}
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	long := strings.Repeat("x", 50)
+	cases := []struct {
+		txt     string
+		start   int
+		end     int
+		excerpt string
+	}{
+		{"one\ntwo three\nfour", 8, 13, "two three"},
+		{"one\ntwo\nthree", 6, 9, "two\nthree"}, // Match spanning lines
+		{long + "abc" + long, 50, 53, "..." + long[:40] + "abc" + long[:40] + "..."},
+		{"éééééééééééééééééééééé" + "abc", 44, 47, "...ééééééééééééééééééééabc"}, // Cut at a character boundary
+	}
+
+	for _, c := range cases {
+		if excerpt := excerpt(c.txt, c.start, c.end); excerpt != c.excerpt {
+			t.Errorf("excerpt(%q, %d, %d) should have returned %q but returned %q", c.txt, c.start, c.end, c.excerpt, excerpt)
+		}
+	}
+}
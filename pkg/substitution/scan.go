@@ -0,0 +1,144 @@
+package substitution
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ScanMode determines where in a comment commands are recognized
+type ScanMode int
+
+const (
+	// ScanStrict only recognizes commands at the very start of a comment. Line commands are only taken alongside an
+	// s or y command, since replies like 2d or 3d are common enough on their own.
+	ScanStrict ScanMode = iota
+	// ScanLenient recognizes commands at the start of any line outside of quotes & code, as well as after a space
+	// when they're closed off with a delimiter (lol s/x/y/). Subreddits opting into it may use line commands alone.
+	ScanLenient
+)
+
+var scanModeNames = []string{"strict", "lenient"}
+
+var quoteMarkerRe = regexp.MustCompile(`^ {0,3}(?:>[ \t]?)+`)
+
+// MaxScanCandidates caps how many places ScanLenient tries to parse commands at in a comment. Each attempt may scan
+// to the end of its line, so the cap keeps comments full of near misses (like s/ over & over) from taking
+// quadratic time.
+const MaxScanCandidates = 50
+
+func (m ScanMode) String() string {
+	if int(m) < len(scanModeNames) {
+		return scanModeNames[m]
+	}
+	return fmt.Sprintf("ScanMode(%d)", int(m))
+}
+
+// ParseScanMode looks up a ScanMode by name ("strict" or "lenient")
+func ParseScanMode(name string) (ScanMode, error) {
+	for i, n := range scanModeNames {
+		if strings.EqualFold(n, name) {
+			return ScanMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown scan mode %q (expected one of %s)", name, strings.Join(scanModeNames, ", "))
+}
+
+// codeSpans returns the [start, end) byte offsets of the inline code & code blocks of a markdown body
+func codeSpans(txt string) [][]int {
+	spans := [][]int{}
+	for _, node := range parseMarkdownNodes(txt) {
+		if node.kind == markdownCode {
+			spans = append(spans, []int{node.start, node.end})
+		}
+	}
+
+	return spans
+}
+
+// hasPatternCommand reports whether any Command of the Pipeline is an s or y command
+func (p Pipeline) hasPatternCommand() bool {
+	for _, cmd := range p {
+		if cmd.Op == OpSubstitute || cmd.Op == OpTransliterate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FindPipeline looks for a Pipeline wherever mode allows in txt, returning it along with the (1-indexed) line its
+// first command was found on. The first candidate that parses wins, giving up after MaxScanCandidates. When none do,
+// the error of the first one beginning a line is returned (or ErrNotCommand).
+func FindPipeline(txt string, mode ScanMode, maxStages *int) (Pipeline, int, error) {
+	if mode == ScanStrict {
+		pipeline, err := ParsePipeline(txt, maxStages)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !pipeline.hasPatternCommand() {
+			return nil, 0, ErrNotCommand
+		}
+		return pipeline, 1, nil
+	}
+
+	code := codeSpans(txt)
+	var firstErr error
+	candidates := 0
+	for i, line := range splitLines(txt) {
+		if quoteMarkerRe.MatchString(txt[line[0]:line[1]]) {
+			continue
+		}
+
+		for pos := line[0]; pos < line[1]; pos++ {
+			if pos > line[0] && !isHorizontalSpace(txt[pos-1]) {
+				continue
+			}
+
+			for len(code) > 0 && code[0][1] <= pos {
+				code = code[1:]
+			}
+			if len(code) > 0 && code[0][0] <= pos {
+				continue
+			}
+
+			t := tokenizer{txt: txt, pos: pos}
+			if !t.atCommand() {
+				continue
+			}
+
+			if candidates == MaxScanCandidates {
+				return nil, 0, scanFailure(firstErr)
+			}
+			candidates++
+
+			// Commands following other text are only taken when they're clearly substitutions
+			beginsLine := len(strings.TrimLeft(txt[line[0]:pos], " \t")) == 0
+			if !beginsLine {
+				probe := t
+				cmd, closed, err := probe.scanCommand()
+				if err != nil || !closed || (cmd.Op != OpSubstitute && cmd.Op != OpTransliterate) {
+					continue
+				}
+			}
+
+			pipeline, err := parsePipeline(t, maxStages)
+			if err == nil {
+				return pipeline, i + 1, nil
+			}
+			if beginsLine && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return nil, 0, scanFailure(firstErr)
+}
+
+// scanFailure is the error FindPipeline returns when no candidate parsed
+func scanFailure(firstErr error) error {
+	if firstErr != nil {
+		return firstErr
+	}
+	return ErrNotCommand
+}
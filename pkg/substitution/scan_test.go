@@ -0,0 +1,83 @@
+package substitution
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseScanMode(t *testing.T) {
+	for _, mode := range []ScanMode{ScanStrict, ScanLenient} {
+		parsed, err := ParseScanMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Errorf("ParseScanMode(%s) should have returned %s but returned (%s, %v)", mode, mode, parsed, err)
+		}
+	}
+
+	if _, err := ParseScanMode("loose"); err == nil {
+		t.Errorf("ParseScanMode(loose) should have errored but did not")
+	}
+}
+
+func TestFindPipeline(t *testing.T) {
+	cases := []struct {
+		input    string
+		mode     ScanMode
+		pipeline Pipeline
+		line     int
+	}{
+		{"s/a/b/", ScanStrict, Pipeline{{ToReplace: "a", ReplaceWith: "b"}}, 1},
+		{"s/a/b/", ScanLenient, Pipeline{{ToReplace: "a", ReplaceWith: "b"}}, 1},
+		{"lol s/x/y/", ScanLenient, Pipeline{{ToReplace: "x", ReplaceWith: "y"}}, 1},
+		{"> s/a/b/\n\n  s/c/d/g", ScanLenient, Pipeline{{ToReplace: "c", ReplaceWith: "d", Flags: Flags{Global: true}}}, 3},
+		{"```\ns/a/b/\n```\nthen `s/c/d/` & s/e/f/", ScanLenient, Pipeline{{ToReplace: "e", ReplaceWith: "f"}}, 4},
+		{"    s/a/b/\n\nnope\n2d", ScanLenient, Pipeline{{Op: OpDelete, Address: Address{Start: LineAddress{Kind: AddressLine, Line: 2}}}}, 4},
+		{"fix it\ns/a/b/\ns/c/d/", ScanLenient, Pipeline{{ToReplace: "a", ReplaceWith: "b"}, {ToReplace: "c", ReplaceWith: "d"}}, 2},
+		{"please explain s/a+/b/ ok", ScanLenient, nil, 0}, // Not closed off
+		{"please explain s/a+/b/", ScanLenient, Pipeline{{ToReplace: "a+", ReplaceWith: "b", Flags: Flags{Explain: true}}}, 1},
+		{"the 2d plot", ScanLenient, nil, 0},
+		{strings.Repeat("x s/", MaxScanCandidates) + "x\nok s/a/b/", ScanLenient, nil, 0}, // Too many candidates before it
+		{strings.Repeat("x s/", MaxScanCandidates-1) + "x\nok s/a/b/", ScanLenient, Pipeline{{ToReplace: "a", ReplaceWith: "b"}}, 2},
+		{"lol s/x/y/", ScanStrict, nil, 0},
+		{"3d", ScanStrict, nil, 0}, // Line commands alone are only taken where subreddits opt into them
+		{"$d", ScanStrict, nil, 0},
+		{"1a\\thanks", ScanStrict, nil, 0},
+		{"3d", ScanLenient, Pipeline{{Op: OpDelete, Address: Address{Start: LineAddress{Kind: AddressLine, Line: 3}}}}, 1},
+		{"3d\ns/a/b/", ScanStrict, Pipeline{{Op: OpDelete, Address: Address{Start: LineAddress{Kind: AddressLine, Line: 3}}}, {ToReplace: "a", ReplaceWith: "b"}}, 1},
+	}
+
+	for _, c := range cases {
+		pipeline, line, err := FindPipeline(c.input, c.mode, nil)
+		if c.pipeline == nil {
+			if !errors.Is(err, ErrNotCommand) {
+				t.Errorf("FindPipeline(%q, %s) should have returned ErrNotCommand but returned (%+v, %d, %v)", c.input, c.mode, pipeline, line, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("FindPipeline(%q, %s) should not have errored but did: %s", c.input, c.mode, err)
+			continue
+		}
+
+		if len(pipeline) != len(c.pipeline) || line != c.line {
+			t.Errorf("FindPipeline(%q, %s) should have returned %+v on line %d but returned %+v on line %d", c.input, c.mode, c.pipeline, c.line, pipeline, line)
+			continue
+		}
+
+		for i := range pipeline {
+			if pipeline[i] != c.pipeline[i] {
+				t.Errorf("FindPipeline(%q, %s) stage %d should have been %+v but was %+v", c.input, c.mode, i+1, c.pipeline[i], pipeline[i])
+			}
+		}
+	}
+}
+
+func TestFindPipelineErrors(t *testing.T) {
+	// A malformed command beginning a line is reported when nothing else is found
+	_, _, err := FindPipeline("hmm\ns/a/b/gz\nlol s/c", ScanLenient, nil)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Pos != 11 {
+		t.Errorf("FindPipeline should have returned a *ParseError at 11 but returned %v", err)
+	}
+}
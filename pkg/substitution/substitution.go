@@ -2,42 +2,262 @@ package substitution
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"regexp/syntax"
+	"strings"
 )
 
-// Command represents a string substitution command
+// ErrNoChange is returned when running a substitution leaves the input as it was
+var ErrNoChange = errors.New("output was same as input")
+
+// InvalidPatternError is returned when the pattern of a command can't be compiled. Offset is the position in the
+// pattern as written that the problem was found at (-1 when it can't be attributed to a position).
+type InvalidPatternError struct {
+	Offset int
+	Reason string
+	Err    error
+}
+
+func (e *InvalidPatternError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("invalid pattern: %s", e.Reason)
+	}
+	return fmt.Sprintf("invalid pattern at offset %d: %s", e.Offset, e.Reason)
+}
+
+func (e *InvalidPatternError) Unwrap() error {
+	return e.Err
+}
+
+// Op is the kind of edit a Command makes
+type Op int
+
+const (
+	// OpSubstitute replaces matches of a pattern (s/pattern/replacement/)
+	OpSubstitute Op = iota
+	// OpTransliterate replaces characters one for one (y/source/destination/)
+	OpTransliterate
+	// OpDelete deletes the addressed lines (2d)
+	OpDelete
+	// OpAppend adds a line of text after each addressed line (a\text)
+	OpAppend
+	// OpInsert adds a line of text before each addressed line (i\text)
+	OpInsert
+	// OpChange replaces the addressed lines with a line of text, once per range (c\text)
+	OpChange
+)
+
+// Command represents a string substitution command. For OpSubstitute, ToReplace & ReplaceWith are written in the
+// syntax of Flags.Mode. For OpTransliterate, they hold the source & destination characters. The line commands
+// (OpDelete, OpAppend, OpInsert & OpChange) only use ReplaceWith, for the text they add.
 type Command struct {
+	Op          Op
+	Address     Address
 	ToReplace   string
 	ReplaceWith string
+	Flags       Flags
 }
 
-// ParseSubstitutionCommand tries to parse a VIM style substitution command from a string
+// ParseSubstitutionCommand tries to parse a VIM style substitution (or sed style transliteration or line) command
+// from the start of a string. Any non alphanumeric character (other than whitespace, backslash & period) can be used as the delimiter
+// & a delimiter escaped with a backslash is taken literally.
 func ParseSubstitutionCommand(txt string) (*Command, error) {
-	re0 := regexp.MustCompile(`(?m:\As\/(.+?)\/(.*?)(?:\/g{0,1}\s*){0,1}$)`)
-	re1 := regexp.MustCompile(`(?m:\As#(.+?)#(.*?)(?:#g{0,1}\s*){0,1}$)`)
-	parts := re0.FindStringSubmatch(txt)
-	if len(parts) != 3 {
-		parts = re1.FindStringSubmatch(txt)
+	t := tokenizer{txt: txt}
+	cmd, _, err := t.scanCommand()
+	return cmd, err
+}
+
+func (s *Command) compile(limits *Limits) (*matcher, error) {
+	return compilePattern(s.ToReplace, s.Flags, limits)
+}
+
+// matcher finds the matches of a compiled pattern
+type matcher struct {
+	source string // the pattern as given to the Engine (without the word boundaries implied by Flags.WholeWord)
+	re     Regexp
+}
+
+// findAll returns the submatch indexes of up to n matches in txt (all of them when n < 0)
+func (m *matcher) findAll(txt string, n int) ([][]int, error) {
+	return m.re.FindAllStringSubmatchIndex(txt, n)
+}
+
+func (m *matcher) matchString(txt string) (bool, error) {
+	matches, err := m.findAll(txt, 1)
+	return len(matches) > 0, err
+}
+
+// compilePattern compiles a pattern written in the syntax of flags.Mode. Word boundaries (& those implied by
+// flags.WholeWord) are checked with Unicode's notion of a word.
+func compilePattern(written string, flags Flags, limits *Limits) (*matcher, error) {
+	if err := limits.checkPatternLength(written); err != nil {
+		return nil, err
+	}
+
+	pattern := written
+	switch flags.Mode {
+	case ModeVim:
+		translated, err := TranslateVimPattern(pattern)
+		if err != nil {
+			var unsupported *UnsupportedConstructError
+			if errors.As(err, &unsupported) {
+				reason := fmt.Sprintf("%s (%s) is not supported", unsupported.Construct, unsupported.Description)
+				return nil, &InvalidPatternError{unsupported.Offset, reason, err}
+			}
+			return nil, &InvalidPatternError{-1, err.Error(), err}
+		}
+		pattern = translated
+	case ModeLiteral:
+		pattern = regexp.QuoteMeta(pattern)
+	}
+
+	if flags.CaseInsensitive {
+		pattern = "(?i)" + pattern
+	}
+
+	source := pattern
+	if flags.WholeWord {
+		pattern = `\b(?:` + pattern + `)\b`
+	}
+
+	engine := DefaultEngine
+	if flags.Backtracking {
+		engine = BacktrackingEngine
+	}
+
+	if err := limits.checkProgramSize(engine, pattern); err != nil {
+		return nil, err
 	}
 
-	if len(parts) != 3 {
-		return nil, errors.New("not a substitution command")
+	re, err := engine.Compile(pattern)
+	if err != nil {
+		var syntaxErr *syntax.Error
+		if errors.As(err, &syntaxErr) {
+			// The offending expression can only be located when it appears as is in the pattern as written
+			offset := -1
+			if len(syntaxErr.Expr) > 0 {
+				offset = strings.Index(written, syntaxErr.Expr)
+			}
+			return nil, &InvalidPatternError{offset, fmt.Sprintf("%s: %s", syntaxErr.Code, syntaxErr.Expr), err}
+		}
+		return nil, &InvalidPatternError{-1, err.Error(), err}
 	}
 
-	return &Command{parts[1], parts[2]}, nil
+	return &matcher{source, re}, nil
 }
 
 // Run executes a Command on a given string
 func (s *Command) Run(txt string) (string, error) {
-	re, err := regexp.Compile(s.ToReplace)
+	result, err := s.Apply(txt)
 	if err != nil {
 		return "", err
 	}
 
-	out := re.ReplaceAllString(txt, s.ReplaceWith)
-	if out == txt {
-		return "", errors.New("output was same as input")
+	return result.Output, nil
+}
+
+// Apply executes a Command on a given string within DefaultLimits, returning the output along with the spans that
+// were changed
+func (s *Command) Apply(txt string) (*Result, error) {
+	return s.ApplyWithLimits(txt, nil)
+}
+
+// ApplyWithLimits executes a Command like Apply, failing as soon as any of the given limits (DefaultLimits when nil)
+// is exceeded
+func (s *Command) ApplyWithLimits(txt string, limits *Limits) (*Result, error) {
+	if limits == nil {
+		limits = &DefaultLimits
+	}
+
+	switch s.Op {
+	case OpTransliterate:
+		return s.transliterate(txt, limits)
+	case OpDelete, OpAppend, OpInsert, OpChange:
+		return s.editLines(txt, limits)
+	}
+
+	pattern, err := s.compile(limits)
+	if err != nil {
+		return nil, err
+	}
+
+	replacement := CompileReplacement(s.ReplaceWith, s.Flags.Mode)
+	limit := s.Flags.matchLimit()
+	if !s.Address.IsZero() || s.Flags.Explain {
+		limit = -1
+	}
+
+	matches, err := pattern.findAll(txt, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Flags.Markdown {
+		scope := markdownScope{parseMarkdownNodes(txt), s.Flags.IncludeCode, s.Flags.IncludeLinks}
+		matches = scope.filter(matches)
+	}
+
+	// Flags pick out matches line by line when the command is addressed
+	groups, err := s.groupMatchesByLine(txt, matches, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	// Explanations cover everything the pattern matches, not only what the other flags would replace
+	if s.Flags.Explain {
+		all := [][]int{}
+		for _, group := range groups {
+			all = append(all, group...)
+		}
+		return &Result{Output: txt, Explanation: s.explain(pattern, txt, all)}, nil
+	}
+
+	matches = nil
+	for _, group := range groups {
+		if s.Flags.CountOnly {
+			matches = append(matches, group...)
+			continue
+		}
+		matches = append(matches, s.Flags.selectMatches(group)...)
+	}
+
+	if s.Flags.CountOnly {
+		return countMatches(txt, matches), nil
+	}
+	if err := limits.checkReplacements(len(matches)); err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	out := strings.Builder{}
+	last := 0
+	for _, m := range matches {
+		out.WriteString(txt[last:m[0]])
+		start := out.Len()
+		expanded := replacement.Expand(nil, pattern.re, txt, m)
+		if s.Flags.PreserveCase {
+			expanded = []byte(preserveCase(txt[m[0]:m[1]], string(expanded)))
+		}
+		out.Write(expanded)
+		result.addChange(Change{start, out.Len(), txt[m[0]:m[1]]})
+		last = m[1]
+
+		// Stop building once the output can't possibly fit
+		if err := limits.checkOutputBytes(out.Len()); err != nil {
+			return nil, err
+		}
+	}
+	out.WriteString(txt[last:])
+
+	result.Output = out.String()
+	if err := limits.CheckOutputLength(result.Output); err != nil {
+		return nil, err
+	}
+	if result.Output == txt {
+		return nil, ErrNoChange
 	}
+	result.dropUnchanged()
 
-	return out, nil
+	return result, nil
 }
@@ -1,6 +1,8 @@
 package substitution
 
 import (
+	"errors"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -15,25 +17,37 @@ func TestParseSubstitutionCommand(t *testing.T) {
 	baseCases := []parseSubCommandTestCase{
 		{"", nil, true},
 		{`s\4\3`, nil, true},
-		{"s/m/r", &Command{"m", "r"}, false},
-		{"s//m//r", &Command{"/m", "/r"}, false},                     // Embedded slashes
-		{"s//m//r/", &Command{"/m", "/r"}, false},                    // Embedded slashes
-		{"s/m/r \nshould not be there", &Command{"m", "r "}, false},  // Trailing space
-		{"s/m/r/", &Command{"m", "r"}, false},                        // Trailing slash
-		{"s/m/r/ \nshould not be there", &Command{"m", "r"}, false},  // Trailing slash & spaces
-		{"s/m/r/g", &Command{"m", "r"}, false},                       // Trailing g
-		{"s/m/r/g \nshould not be there", &Command{"m", "r"}, false}, // Trailing g, slash & spaces
-		{"\ns/m/r", nil, true},                                       // Command not on first line
-		{" s/m/r", nil, true},                                        // Command starts with space
-		{"s/sp ace /s pace ", &Command{"sp ace ", "s pace "}, false},
-		{"s/sp ace /s pace \nshould not be there", &Command{"sp ace ", "s pace "}, false},
+		{"s/m/r", &Command{ToReplace: "m", ReplaceWith: "r"}, false},
+		{"s//m//r", &Command{ToReplace: "/m", ReplaceWith: "/r"}, false},                                                 // Embedded slashes
+		{"s//m//r/", &Command{ToReplace: "/m", ReplaceWith: "/r"}, false},                                                // Embedded slashes
+		{"s/m/r \nshould not be there", &Command{ToReplace: "m", ReplaceWith: "r "}, false},                              // Trailing space
+		{"s/m/r/", &Command{ToReplace: "m", ReplaceWith: "r"}, false},                                                    // Trailing slash
+		{"s/m/r/ \nshould not be there", &Command{ToReplace: "m", ReplaceWith: "r"}, false},                              // Trailing slash & spaces
+		{"s/m/r/g", &Command{ToReplace: "m", ReplaceWith: "r", Flags: Flags{Global: true}}, false},                       // Trailing g
+		{"s/m/r/g \nshould not be there", &Command{ToReplace: "m", ReplaceWith: "r", Flags: Flags{Global: true}}, false}, // Trailing g, slash & spaces
+		{"s/m/r/gR", &Command{ToReplace: "m", ReplaceWith: "r", Flags: Flags{Global: true, Mode: ModeGo}}, false},
+		{"s/m/r/gi", &Command{ToReplace: "m", ReplaceWith: "r", Flags: Flags{Global: true, CaseInsensitive: true}}, false},
+		{"s/m/r/2", &Command{ToReplace: "m", ReplaceWith: "r", Flags: Flags{Occurrence: 2}}, false},
+		{"s/m/r/3g", &Command{ToReplace: "m", ReplaceWith: "r", Flags: Flags{Global: true, Occurrence: 3}}, false},
+		{"s/m/r/x", nil, true}, // Unknown flag
+		{"s/m/r/0", nil, true}, // Zero occurrence
+		{"s/m/r/c d", &Command{ToReplace: "m", ReplaceWith: "r/c d"}, false}, // Not a flag segment
+		{"\ns/m/r", nil, true}, // Command not on first line
+		{" s/m/r", nil, true},  // Command starts with space
+		{"s.o.b.", nil, true},  // Periods aren't delimiters
+		{"s/sp ace /s pace ", &Command{ToReplace: "sp ace ", ReplaceWith: "s pace "}, false},
+		{"s/sp ace /s pace \nshould not be there", &Command{ToReplace: "sp ace ", ReplaceWith: "s pace "}, false},
 	}
 
 	cases := baseCases[:]
 	for _, c := range baseCases {
 		var cmd *Command = nil
 		if c.cmd != nil {
-			cmd = &Command{strings.Replace(c.cmd.ToReplace, "/", "#", -1), strings.Replace(c.cmd.ReplaceWith, "/", "#", -1)}
+			cmd = &Command{
+				ToReplace:   strings.Replace(c.cmd.ToReplace, "/", "#", -1),
+				ReplaceWith: strings.Replace(c.cmd.ReplaceWith, "/", "#", -1),
+				Flags:       c.cmd.Flags,
+			}
 		}
 		cases = append(cases,
 			parseSubCommandTestCase{
@@ -71,14 +85,16 @@ func TestParseSubstitutionCommand(t *testing.T) {
 			continue
 		}
 
-		if c.cmd.ToReplace != out.ToReplace || c.cmd.ReplaceWith != out.ReplaceWith {
+		if c.cmd.ToReplace != out.ToReplace || c.cmd.ReplaceWith != out.ReplaceWith || c.cmd.Flags != out.Flags {
 			t.Errorf(
-				"ParseSubstitutionCommand(%s) should have returned Command{%s, %s} but returned Command{%s, %s}",
+				"ParseSubstitutionCommand(%s) should have returned Command{%s, %s, %+v} but returned Command{%s, %s, %+v}",
 				c.input,
 				c.cmd.ToReplace,
 				c.cmd.ReplaceWith,
+				c.cmd.Flags,
 				out.ToReplace,
 				out.ReplaceWith,
+				out.Flags,
 			)
 		}
 	}
@@ -91,13 +107,59 @@ func TestSubstitutionCommandRun(t *testing.T) {
 		out string
 		err bool
 	}{
-		{"text", Command{"ex", "ex"}, "", true},
-		{"text", Command{"f", "g"}, "", true},
-		{"text beep", Command{"ext bee", "t e"}, "tt ep", false},
-		{"text", Command{`\w+`, "blah"}, "blah", false}, // Accepts actual regexp
-		{"23", Command{`(\d)`, `<$1>`}, "<2><3>", false},
-		{"23", Command{`(\d`, `<$1>`}, "", true},        // Erroneous regexp
-		{`\text\`, Command{`\\`, `|`}, "|text|", false}, // Can work with escaped characters
+		{"text", Command{ToReplace: "ex", ReplaceWith: "ex"}, "", true},
+		{"text", Command{ToReplace: "f", ReplaceWith: "g"}, "", true},
+		{"text beep", Command{ToReplace: "ext bee", ReplaceWith: "t e"}, "tt ep", false},
+		{"text", Command{ToReplace: `\w\+`, ReplaceWith: "blah"}, "blah", false},                            // Accepts actual regexp
+		{"text", Command{ToReplace: `\w+`, ReplaceWith: "blah", Flags: Flags{Mode: ModeGo}}, "blah", false}, // Accepts Go regexp
+		{"23", Command{ToReplace: `(\d)`, ReplaceWith: `<$1>`, Flags: Flags{Global: true, Mode: ModeGo}}, "<2><3>", false},
+		{"23", Command{ToReplace: `\(\d\)`, ReplaceWith: `<\1>`, Flags: Flags{Global: true}}, "<2><3>", false},
+		{"23", Command{ToReplace: `\v(\d)`, ReplaceWith: `$1`}, "$13", false},                               // $ is literal in VIM syntax
+		{"(2)", Command{ToReplace: `(\d)`, ReplaceWith: `x`}, "x", false},                                   // Parentheses are literal in VIM's magic syntax
+		{"23", Command{ToReplace: `(\d`, ReplaceWith: `<$1>`, Flags: Flags{Mode: ModeGo}}, "", true},        // Erroneous regexp
+		{`\text\`, Command{ToReplace: `\\`, ReplaceWith: `|`, Flags: Flags{Global: true}}, "|text|", false}, // Can work with escaped characters
+		{"a a a", Command{ToReplace: "a", ReplaceWith: "b"}, "b a a", false},                                // Only first match without g
+		{"a a a", Command{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Occurrence: 2}}, "a b a", false},   // Only Nth match
+		{"a a a", Command{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Occurrence: 4}}, "", true},         // Fewer than N matches
+		{"a a a", Command{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Global: true, Occurrence: 2}}, "a b b", false},
+		{"a b", Command{ToReplace: `\(\d\)\1`, ReplaceWith: "b"}, "", true}, // Backreferences can't be translated to RE2
+		{"a~b", Command{ToReplace: "~", ReplaceWith: "-"}, "a-b", false},
+		{"C++ & C", Command{ToReplace: "C++", ReplaceWith: "Go", Flags: Flags{Mode: ModeLiteral}}, "Go & C", false},
+		{"it's $5", Command{ToReplace: "$5", ReplaceWith: `$50 \1 &`, Flags: Flags{Mode: ModeLiteral}}, `it's $50 \1 &`, false},
+		{"a `a` [a](a) a", Command{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Global: true, Markdown: true}}, "b `a` [b](a) b", false},
+		{"`a` a", Command{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Markdown: true}}, "`a` b", false}, // First match outside code
+		{"`a` a", Command{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Global: true, Markdown: true, IncludeCode: true}}, "`b` b", false},
+		{"[a](a)", Command{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Global: true, Markdown: true, IncludeLinks: true}}, "[b](b)", false},
+		{"`a`", Command{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Markdown: true}}, "", true},
+		{"_a_ b_c", Command{ToReplace: "_", ReplaceWith: "-", Flags: Flags{Global: true, Markdown: true}}, "_a_ b-c", false}, // Emphasis markup is left alone
+		{"A a", Command{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Global: true, CaseInsensitive: true}}, "b b", false},
+		{"cafés café", Command{ToReplace: `\<café\>`, ReplaceWith: "tea"}, "cafés tea", false},
+		{"cafés café", Command{ToReplace: `\bcafé\b`, ReplaceWith: "tea", Flags: Flags{Mode: ModeGo}}, "cafés tea", false},
+		{"x café!", Command{ToReplace: `x \<café\>!`, ReplaceWith: "tea"}, "tea", false}, // Word boundaries inside the pattern too
+		{"xcafé", Command{ToReplace: `x\<café`, ReplaceWith: "tea"}, "", true},
+		{"-éa", Command{ToReplace: `-\béa`, ReplaceWith: "x", Flags: Flags{Mode: ModeGo}}, "x", false},
+		{"aé a-é", Command{ToReplace: `a\B.`, ReplaceWith: "x", Flags: Flags{Global: true, Mode: ModeGo}}, "x a-é", false},
+		{"x café!", Command{ToReplace: `\(x\) \<\(café\)\>!`, ReplaceWith: `\2 \1`}, "café x", false},
+		{"Straße straße", Command{ToReplace: "straße", ReplaceWith: "Weg", Flags: Flags{WholeWord: true}}, "Straße Weg", false},
+		{"кот котик кот", Command{ToReplace: "кот", ReplaceWith: "пёс", Flags: Flags{Global: true, WholeWord: true}}, "пёс котик пёс", false},
+		{"catalog", Command{ToReplace: "cat", ReplaceWith: "dog", Flags: Flags{WholeWord: true}}, "", true},
+		{"ab xy", Command{ToReplace: `\(a\|ab\)\>`, ReplaceWith: "X"}, "X xy", false}, // Alternatives failing a word boundary give way to the next
+		{"ab xy", Command{ToReplace: `(a|ab)\b`, ReplaceWith: "X", Flags: Flags{Mode: ModeGo}}, "X xy", false},
+		{"ab xy", Command{ToReplace: `a\|ab`, ReplaceWith: "X", Flags: Flags{WholeWord: true}}, "X xy", false},
+		{"xy ab", Command{ToReplace: `\<\(x\|xy\)\>`, ReplaceWith: "Z"}, "Z ab", false},
+		{"éa xy", Command{ToReplace: `\(é\|éa\)\>`, ReplaceWith: "X"}, "X xy", false},
+		{"cafés café", Command{ToReplace: `café\%(s\)\=\>`, ReplaceWith: "tea", Flags: Flags{Global: true}}, "tea tea", false}, // Optional tails
+		{"écoles école", Command{ToReplace: `école\>`, ReplaceWith: "X", Flags: Flags{Global: true}}, "écoles X", false},
+		{"ça ça", Command{ToReplace: `\(\<ça \)\+`, ReplaceWith: "x"}, "xça", false}, // Word boundaries may repeat
+		{"Cat cat CAT", Command{ToReplace: "cat", ReplaceWith: "dog", Flags: Flags{Global: true, CaseInsensitive: true, PreserveCase: true}}, "Dog dog DOG", false},
+		{"Cat", Command{ToReplace: `\(c\)at`, ReplaceWith: `\1ow`, Flags: Flags{CaseInsensitive: true, PreserveCase: true}}, "Cow", false},
+		{"a a\na a\na a", Command{Address: Address{Start: LineAddress{Kind: AddressLine, Line: 2}}, ToReplace: "a", ReplaceWith: "b"}, "a a\nb a\na a", false},
+		{"a a\na a\na a", Command{Address: Address{Start: LineAddress{Kind: AddressLine, Line: 2}, End: LineAddress{Kind: AddressLast}}, ToReplace: "a", ReplaceWith: "b"}, "a a\nb a\nb a", false}, // First match of each line
+		{"a a\na a", Command{Address: Address{Start: LineAddress{Kind: AddressLine, Line: 1}, End: LineAddress{Kind: AddressLast}}, ToReplace: "a", ReplaceWith: "b", Flags: Flags{Occurrence: 2}}, "a b\na b", false},
+		{"x a\ny a", Command{Address: Address{Start: LineAddress{Kind: AddressPattern, Pattern: `^y`}}, ToReplace: "a", ReplaceWith: "b"}, "x a\ny b", false},
+		{"a\na", Command{Address: Address{Start: LineAddress{Kind: AddressLine, Line: 3}}, ToReplace: "a", ReplaceWith: "b"}, "", true},
+		{"a\na", Command{Address: Address{Start: LineAddress{Kind: AddressPattern, Pattern: `\(`}}, ToReplace: "a", ReplaceWith: "b"}, "", true}, // Invalid address pattern
+		{"ab\nab", Command{Op: OpTransliterate, Address: Address{Start: LineAddress{Kind: AddressLast}}, ToReplace: "ab", ReplaceWith: "ba"}, "ab\nba", false},
 	}
 
 	for _, c := range cases {
@@ -115,3 +177,99 @@ func TestSubstitutionCommandRun(t *testing.T) {
 		}
 	}
 }
+
+func TestSubstitutionCommandApply(t *testing.T) {
+	cases := []struct {
+		in      string
+		cmd     Command
+		changes []Change
+	}{
+		{"a b a", Command{ToReplace: "a", ReplaceWith: "xy", Flags: Flags{Global: true}}, []Change{{0, 2, "a"}, {5, 7, "a"}}},
+		{"aab", Command{ToReplace: "a", ReplaceWith: "x", Flags: Flags{Global: true}}, []Change{{0, 2, "aa"}}}, // Contiguous matches merge
+		{"a b", Command{ToReplace: "a ", ReplaceWith: ""}, []Change{{0, 0, "a "}}},
+		{"ab ab", Command{ToReplace: `\(.\)b`, ReplaceWith: `\1c`, Flags: Flags{Global: true}}, []Change{{0, 2, "ab"}, {3, 5, "ab"}}},
+		{"a a", Command{ToReplace: "a", ReplaceWith: "a", Flags: Flags{Occurrence: 1}}, nil},
+	}
+
+	for _, c := range cases {
+		result, err := c.cmd.Apply(c.in)
+		if c.changes == nil {
+			if err == nil {
+				t.Errorf("Command{%s, %s}.Apply(%s) should have errored but did not", c.cmd.ToReplace, c.cmd.ReplaceWith, c.in)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Command{%s, %s}.Apply(%s) should not have errored but did: %s", c.cmd.ToReplace, c.cmd.ReplaceWith, c.in, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(result.Changes, c.changes) {
+			t.Errorf("Command{%s, %s}.Apply(%s) should have changed %+v but changed %+v", c.cmd.ToReplace, c.cmd.ReplaceWith, c.in, c.changes, result.Changes)
+		}
+	}
+}
+
+func TestSubstitutionErrors(t *testing.T) {
+	if _, err := ParseSubstitutionCommand("not a command"); !errors.Is(err, ErrNotCommand) {
+		t.Errorf("ParseSubstitutionCommand(not a command) should have returned ErrNotCommand but returned %v", err)
+	}
+
+	cmd := Command{ToReplace: "a", ReplaceWith: "a"}
+	if _, err := cmd.Run("abc"); !errors.Is(err, ErrNoChange) {
+		t.Errorf("Command{a, a}.Run(abc) should have returned ErrNoChange but returned %v", err)
+	}
+
+	cases := []struct {
+		cmd         Command
+		offset      int
+		unsupported bool
+	}{
+		{Command{ToReplace: `x(\d`, ReplaceWith: "b", Flags: Flags{Mode: ModeGo}}, 0, false},
+		{Command{ToReplace: `a**`, ReplaceWith: "b", Flags: Flags{Mode: ModeGo}}, 1, false},
+		{Command{ToReplace: `\(\d\)\1`, ReplaceWith: "b"}, 6, true},
+		{Command{ToReplace: `x(\d`, ReplaceWith: "b", Flags: Flags{Mode: ModeGo, CaseInsensitive: true}}, -1, false}, // Error refers to the compiled pattern
+	}
+
+	for _, c := range cases {
+		_, err := c.cmd.Run("abc")
+
+		var patternErr *InvalidPatternError
+		if !errors.As(err, &patternErr) || patternErr.Offset != c.offset {
+			t.Errorf("Command{%s, %s}.Run(abc) should have returned an *InvalidPatternError at %d but returned %v", c.cmd.ToReplace, c.cmd.ReplaceWith, c.offset, err)
+			continue
+		}
+
+		var unsupported *UnsupportedConstructError
+		if errors.As(err, &unsupported) != c.unsupported {
+			t.Errorf("Command{%s, %s}.Run(abc) wrapping an *UnsupportedConstructError should have been %t", c.cmd.ToReplace, c.cmd.ReplaceWith, c.unsupported)
+		}
+	}
+}
+
+func TestSubstitutionCommandCount(t *testing.T) {
+	cases := []struct {
+		in      string
+		cmd     Command
+		matches []Match
+	}{
+		{"a b a", Command{ToReplace: "a", Flags: Flags{Global: true, CountOnly: true}}, []Match{{0, 1, "a b a"}, {4, 5, "a b a"}}},
+		{"a b a", Command{ToReplace: "a", Flags: Flags{CountOnly: true}}, []Match{{0, 1, "a b a"}, {4, 5, "a b a"}}}, // Counts every match
+		{"x x x", Command{ToReplace: "x", Flags: Flags{CountOnly: true, Occurrence: 2}}, []Match{{0, 1, "x x x"}, {2, 3, "x x x"}, {4, 5, "x x x"}}},
+		{"a\nb a", Command{Address: Address{Start: LineAddress{Kind: AddressLast}}, ToReplace: "a", Flags: Flags{Global: true, CountOnly: true}}, []Match{{4, 5, "b a"}}},
+		{"b", Command{ToReplace: "a", Flags: Flags{Global: true, CountOnly: true}}, []Match{}},
+	}
+
+	for _, c := range cases {
+		result, err := c.cmd.Apply(c.in)
+		if err != nil {
+			t.Errorf("%+v.Apply(%q) should not have errored but did: %s", c.cmd, c.in, err)
+			continue
+		}
+
+		if !result.Counted || result.Output != c.in || len(result.Changes) > 0 || !reflect.DeepEqual(result.Matches, c.matches) {
+			t.Errorf("%+v.Apply(%q) should have only counted %+v but returned %+v", c.cmd, c.in, c.matches, *result)
+		}
+	}
+}
@@ -0,0 +1,490 @@
+package substitution
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParseError is returned when a string looks like a command but is malformed. Pos is the byte offset of the
+// problem within the parsed string.
+type ParseError struct {
+	Pos    int
+	Reason string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse error at position %d: %s", e.Pos, e.Reason)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ErrNotCommand is returned when a string doesn't begin with a substitution command
+var ErrNotCommand = errors.New("not a substitution command")
+
+// Characters that would need escaping to be literal in a Go regular expression
+const regexpMetaCharacters = `\.+*?()|[]{}^$`
+
+// isDelimiter reports whether r can separate the parts of a command. Like sed, any character that isn't
+// alphanumeric may be used except for whitespace & backslash. Unlike sed, a period isn't either, so that dotted
+// abbreviations (s.o.b.) aren't taken for commands.
+func isDelimiter(r rune) bool {
+	return r != utf8.RuneError && r != '\\' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) && unicode.IsPrint(r)
+}
+
+func isFlagCharacter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '?'
+}
+
+// explainPrefix may precede a command to explain it instead of running it (like the ? flag)
+const explainPrefix = "explain "
+
+func isHorizontalSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+// tokenizer scans commands out of a string
+type tokenizer struct {
+	txt string
+	pos int
+}
+
+// commandOps maps the letter that begins a command to its Op
+var commandOps = map[byte]Op{
+	's': OpSubstitute,
+	'y': OpTransliterate,
+}
+
+// atCommand reports whether a command (possibly preceded by an address) begins at the current position
+func (t *tokenizer) atCommand() bool {
+	probe := *t
+	probe.skipExplainPrefix()
+	address, err := probe.scanAddress()
+	if err != nil {
+		return false
+	}
+
+	return probe.atOp() || probe.atLineOp(!address.IsZero())
+}
+
+// skipExplainPrefix moves past explainPrefix (& any further spaces) if it's at the current position, reporting
+// whether it was
+func (t *tokenizer) skipExplainPrefix() bool {
+	if !strings.HasPrefix(t.txt[t.pos:], explainPrefix) {
+		return false
+	}
+
+	t.pos += len(explainPrefix)
+	for t.pos < len(t.txt) && isHorizontalSpace(t.txt[t.pos]) {
+		t.pos++
+	}
+
+	return true
+}
+
+// atOp reports whether the letter of a command followed by a delimiter is at the current position
+func (t *tokenizer) atOp() bool {
+	if t.pos >= len(t.txt) {
+		return false
+	}
+
+	if _, ok := commandOps[t.txt[t.pos]]; !ok {
+		return false
+	}
+
+	r, _ := utf8.DecodeRuneInString(t.txt[t.pos+1:])
+	return isDelimiter(r)
+}
+
+// lineOps maps the letter that begins a line command to its Op
+var lineOps = map[byte]Op{
+	'd': OpDelete,
+	'a': OpAppend,
+	'i': OpInsert,
+	'c': OpChange,
+}
+
+// atLineOp reports whether a line command is at the current position: a, i or c followed by a backslash, or d
+// followed by the end of the command. Line commands have to be addressed, since editing every line is never what's
+// wanted & replies that merely begin with something like c\ shouldn't be taken as commands.
+func (t *tokenizer) atLineOp(addressed bool) bool {
+	if !addressed || t.pos >= len(t.txt) {
+		return false
+	}
+
+	op, ok := lineOps[t.txt[t.pos]]
+	switch {
+	case !ok:
+		return false
+	case op != OpDelete:
+		return strings.HasPrefix(t.txt[t.pos+1:], `\`)
+	}
+
+	rest := strings.TrimLeft(t.restOfLine()[1:], " \t\r")
+	return len(rest) == 0 || rest[0] == ';'
+}
+
+// restOfLine returns the remainder of the current line
+func (t *tokenizer) restOfLine() string {
+	rest := t.txt[t.pos:]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		return rest[:i]
+	}
+
+	return rest
+}
+
+// skipLine moves to the beginning of the next line
+func (t *tokenizer) skipLine() {
+	t.pos += len(t.restOfLine())
+	if t.pos < len(t.txt) {
+		t.pos++
+	}
+}
+
+func (t *tokenizer) atLineEnd() bool {
+	return t.pos >= len(t.txt) || t.txt[t.pos] == '\n'
+}
+
+// scanLineAddress scans a line number, $ or /pattern/ if one is at the current position. Patterns are returned with
+// their escapes intact.
+func (t *tokenizer) scanLineAddress() (LineAddress, error) {
+	rest := t.txt[t.pos:]
+	switch {
+	case len(rest) > 0 && rest[0] >= '0' && rest[0] <= '9':
+		end := 1
+		for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+			end++
+		}
+
+		n, err := strconv.Atoi(rest[:end])
+		if err != nil {
+			return LineAddress{}, &ParseError{Pos: t.pos, Reason: "line number out of range", Err: err}
+		}
+
+		t.pos += end
+		return LineAddress{Kind: AddressLine, Line: n}, nil
+	case strings.HasPrefix(rest, "$"):
+		t.pos++
+		return LineAddress{Kind: AddressLast}, nil
+	case strings.HasPrefix(rest, "/"):
+		t.pos++
+		pattern, err := t.scanPattern("/")
+		if err != nil {
+			return LineAddress{}, err
+		}
+
+		return LineAddress{Kind: AddressPattern, Pattern: pattern}, nil
+	}
+
+	return LineAddress{}, nil
+}
+
+// scanAddress scans the address preceding a command if there is one - a line address, a range of two separated by
+// a comma or % for every line
+func (t *tokenizer) scanAddress() (Address, error) {
+	if strings.HasPrefix(t.txt[t.pos:], "%") {
+		t.pos++
+		return Address{LineAddress{Kind: AddressLine, Line: 1}, LineAddress{Kind: AddressLast}}, nil
+	}
+
+	start, err := t.scanLineAddress()
+	if err != nil || start.Kind == AddressNone {
+		return Address{}, err
+	}
+
+	address := Address{Start: start}
+	if strings.HasPrefix(t.txt[t.pos:], ",") {
+		t.pos++
+		end, err := t.scanLineAddress()
+		if err != nil {
+			return Address{}, err
+		}
+
+		if end.Kind == AddressNone {
+			return Address{}, &ParseError{Pos: t.pos, Reason: "expected an address after ,"}
+		}
+		address.End = end
+	}
+
+	return address, nil
+}
+
+// unescapeAddress makes escaped delimiters in the patterns of an address literal for the syntax of the given Mode
+func unescapeAddress(address Address, mode Mode) Address {
+	for _, l := range []*LineAddress{&address.Start, &address.End} {
+		if l.Kind == AddressPattern {
+			l.Pattern = unescapePattern(l.Pattern, "/", mode)
+		}
+	}
+
+	return address
+}
+
+// closesAt reports whether the delimiter at pos closes off the replacement, i.e. it's only followed by flags &
+// whitespace up to the end of the line (or a ; separating another command). The flags & the position after them
+// are returned.
+func (t *tokenizer) closesAt(pos int, delim string) (string, int, bool) {
+	flagsStart := pos + len(delim)
+	end := flagsStart
+	for end < len(t.txt) && isFlagCharacter(t.txt[end]) {
+		end++
+	}
+	flags := t.txt[flagsStart:end]
+
+	for end < len(t.txt) && isHorizontalSpace(t.txt[end]) {
+		end++
+	}
+
+	if end < len(t.txt) && t.txt[end] != '\n' && t.txt[end] != ';' {
+		return "", 0, false
+	}
+
+	return flags, end, true
+}
+
+// scanPattern scans the pattern up to (& past) the delimiter closing it off. The pattern can't be empty, so a
+// delimiter directly following the opening one is literal. The pattern is returned with its escapes intact.
+func (t *tokenizer) scanPattern(delim string) (string, error) {
+	start := t.pos
+	for !t.atLineEnd() {
+		rest := t.txt[t.pos:]
+		switch {
+		case strings.HasPrefix(rest, `\`+delim):
+			t.pos += 1 + len(delim)
+		case strings.HasPrefix(rest, `\`) && len(rest) > 1 && rest[1] != '\n':
+			t.pos += 2
+		case strings.HasPrefix(rest, delim) && t.pos > start:
+			pattern := t.txt[start:t.pos]
+			t.pos += len(delim)
+			return pattern, nil
+		default:
+			_, size := utf8.DecodeRuneInString(rest)
+			t.pos += size
+		}
+	}
+
+	return "", &ParseError{Pos: start, Reason: "unterminated pattern"}
+}
+
+// scanReplacement scans the replacement & any flags following it (parsed with parseFlags). A delimiter only closes
+// off the replacement when it's followed by flags & the end of the line, otherwise it's taken literally. The
+// replacement is returned with its escapes intact & the returned bool reports whether a closing delimiter was found.
+func (t *tokenizer) scanReplacement(delim string, parseFlags func(string) (Flags, error)) (string, Flags, bool, error) {
+	start := t.pos
+	for !t.atLineEnd() {
+		rest := t.txt[t.pos:]
+		switch {
+		case strings.HasPrefix(rest, `\`+delim):
+			t.pos += 1 + len(delim)
+			continue
+		case strings.HasPrefix(rest, `\`) && len(rest) > 1 && rest[1] != '\n':
+			t.pos += 2
+			continue
+		case strings.HasPrefix(rest, delim):
+			if rawFlags, end, ok := t.closesAt(t.pos, delim); ok {
+				replacement := t.txt[start:t.pos]
+
+				flags, err := parseFlags(rawFlags)
+				if err == nil {
+					t.pos = end
+					return replacement, flags, true, nil
+				}
+
+				// An empty replacement is only recognized when followed by valid flags (e.g. s//m//r is "/m" -> "/r")
+				if len(replacement) > 0 {
+					var flagErr *FlagError
+					errors.As(err, &flagErr)
+					return "", Flags{}, false, &ParseError{Pos: t.pos + len(delim) + flagErr.Offset, Reason: flagErr.Reason, Err: err}
+				}
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(rest)
+		t.pos += size
+	}
+
+	return t.txt[start:t.pos], Flags{}, false, nil
+}
+
+// replaceEscapedDelimiter replaces each backslash escaped delimiter in txt with the given string
+func replaceEscapedDelimiter(txt string, delim string, with string) string {
+	out := strings.Builder{}
+	for i := 0; i < len(txt); i++ {
+		switch {
+		case strings.HasPrefix(txt[i:], `\`+delim):
+			out.WriteString(with)
+			i += len(delim)
+		case txt[i] == '\\' && i+1 < len(txt):
+			out.WriteString(txt[i : i+2])
+			i++
+		default:
+			out.WriteByte(txt[i])
+		}
+	}
+
+	return out.String()
+}
+
+// unescapePattern makes escaped delimiters in the pattern literal for the syntax of the given Mode
+func unescapePattern(pattern string, delim string, mode Mode) string {
+	literalDelim := delim
+	switch {
+	case mode == ModeLiteral:
+		// Everything is taken as is
+	case mode == ModeVim && strings.ContainsAny(delim, vimMagicCharacters):
+		// Whether an escaped special is literal depends on the magic level, but a character code never is
+		r, _ := utf8.DecodeRuneInString(delim)
+		literalDelim = `\%d` + strconv.Itoa(int(r))
+	case mode == ModeVim:
+		literalDelim = `\` + delim
+	case strings.ContainsAny(delim, regexpMetaCharacters):
+		literalDelim = `\` + delim
+	}
+
+	return replaceEscapedDelimiter(pattern, delim, literalDelim)
+}
+
+// unescapeReplacement makes escaped delimiters in the replacement literal for the syntax of the given Mode. VIM
+// syntax already treats an escaped character other than its specials as literal.
+func unescapeReplacement(replacement string, delim string, mode Mode) string {
+	if mode == ModeVim {
+		return replacement
+	}
+
+	literalDelim := delim
+	if delim == "$" && mode == ModeGo {
+		literalDelim = "$$"
+	}
+
+	return replaceEscapedDelimiter(replacement, delim, literalDelim)
+}
+
+// scanCommand scans a single command beginning at the current position. It reports whether the command was
+// closed off with a trailing delimiter.
+func (t *tokenizer) scanCommand() (*Command, bool, error) {
+	if !t.atCommand() {
+		return nil, false, ErrNotCommand
+	}
+
+	explainStart := t.pos
+	explain := t.skipExplainPrefix()
+
+	start := t.pos
+	address, _ := t.scanAddress()
+	if (address.Start.Kind == AddressLine && address.Start.Line == 0) || (address.End.Kind == AddressLine && address.End.Line == 0) {
+		return nil, false, &ParseError{Pos: start, Reason: "line numbers start at 1"}
+	}
+
+	if t.atLineOp(!address.IsZero()) {
+		if explain {
+			return nil, false, &ParseError{Pos: explainStart, Reason: "only s commands can be explained"}
+		}
+
+		cmd := t.scanLineCommand()
+		cmd.Address = unescapeAddress(address, cmd.Flags.Mode)
+		return cmd, true, nil
+	}
+
+	op := commandOps[t.txt[t.pos]]
+	_, size := utf8.DecodeRuneInString(t.txt[t.pos+1:])
+	delim := t.txt[t.pos+1 : t.pos+1+size]
+	t.pos += 1 + size
+
+	pattern, err := t.scanPattern(delim)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if op == OpTransliterate {
+		if explain {
+			return nil, false, &ParseError{Pos: explainStart, Reason: "only s commands can be explained"}
+		}
+
+		cmd, closed, err := t.scanTransliteration(pattern, delim)
+		if err != nil {
+			return nil, false, err
+		}
+
+		cmd.Address = unescapeAddress(address, cmd.Flags.Mode)
+		return cmd, closed, nil
+	}
+
+	replacement, flags, closed, err := t.scanReplacement(delim, ParseFlags)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cmd := &Command{
+		Address:     unescapeAddress(address, flags.Mode),
+		ToReplace:   unescapePattern(pattern, delim, flags.Mode),
+		ReplaceWith: unescapeReplacement(replacement, delim, flags.Mode),
+		Flags:       flags,
+	}
+	cmd.Flags.Explain = cmd.Flags.Explain || explain
+
+	return cmd, closed, nil
+}
+
+// scanLineCommand scans the line command at the current position. Like sed, the text of a\, i\ & c\ may begin on
+// the following line & carries on over further lines while each ends in a backslash.
+func (t *tokenizer) scanLineCommand() *Command {
+	op := lineOps[t.txt[t.pos]]
+	t.pos++
+	if op == OpDelete {
+		for t.pos < len(t.txt) && isHorizontalSpace(t.txt[t.pos]) {
+			t.pos++
+		}
+		return &Command{Op: op}
+	}
+
+	// Skip the backslash & a line break directly following it
+	t.pos++
+	if strings.HasPrefix(t.txt[t.pos:], "\n") {
+		t.pos++
+	}
+
+	lines := []string{}
+	for {
+		line := t.restOfLine()
+		t.pos += len(line)
+		if !strings.HasSuffix(line, `\`) || t.pos == len(t.txt) {
+			lines = append(lines, line)
+			break
+		}
+
+		lines = append(lines, strings.TrimSuffix(line, `\`))
+		t.pos++
+	}
+
+	return &Command{Op: op, ReplaceWith: strings.Join(lines, "\n")}
+}
+
+// scanTransliteration scans the rest of a y command whose source has already been scanned. Escaped delimiters are
+// made literal & both sides must hold the same number of characters.
+func (t *tokenizer) scanTransliteration(source string, delim string) (*Command, bool, error) {
+	start := t.pos
+	destination, flags, closed, err := t.scanReplacement(delim, parseTransliterationFlags)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cmd := &Command{
+		Op:          OpTransliterate,
+		ToReplace:   replaceEscapedDelimiter(source, delim, delim),
+		ReplaceWith: replaceEscapedDelimiter(destination, delim, delim),
+		Flags:       flags,
+	}
+
+	if _, err := cmd.compileTransliteration(); err != nil {
+		return nil, false, &ParseError{Pos: start, Reason: err.Error(), Err: err}
+	}
+
+	return cmd, closed, nil
+}
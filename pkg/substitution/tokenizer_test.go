@@ -0,0 +1,114 @@
+package substitution
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTokenizerScanCommand(t *testing.T) {
+	cases := []struct {
+		input  string
+		cmd    *Command
+		closed bool
+		end    int
+	}{
+		{`s|a|b|`, &Command{ToReplace: "a", ReplaceWith: "b"}, true, 6},
+		{`s,a,b,g`, &Command{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Global: true}}, true, 7},
+		{`s:a:b`, &Command{ToReplace: "a", ReplaceWith: "b"}, false, 5},
+		{`s→a→b→`, &Command{ToReplace: "a", ReplaceWith: "b"}, true, 12}, // Multi-byte delimiter
+		{`s/a\/b/c/`, &Command{ToReplace: `a\/b`, ReplaceWith: "c"}, true, 9},
+		{`s/a\/b/c/R`, &Command{ToReplace: "a/b", ReplaceWith: "c", Flags: Flags{Mode: ModeGo}}, true, 10},
+		{`s/a/b\/c/`, &Command{ToReplace: "a", ReplaceWith: `b\/c`}, true, 9}, // VIM syntax handles the escape itself
+		{`s/a/b\/c/R`, &Command{ToReplace: "a", ReplaceWith: "b/c", Flags: Flags{Mode: ModeGo}}, true, 10},
+		{`s|a\|b|c|`, &Command{ToReplace: `a\%d124b`, ReplaceWith: "c"}, true, 9}, // Escaped delimiter stays literal in the pattern
+		{`s|a\|b|c|R`, &Command{ToReplace: `a\|b`, ReplaceWith: "c", Flags: Flags{Mode: ModeGo}}, true, 10},
+		{`s$a$b\$c$R`, &Command{ToReplace: "a", ReplaceWith: "b$$c", Flags: Flags{Mode: ModeGo}}, true, 10}, // Escaped delimiter stays literal in Go syntax
+		{`s/\d+/\n/`, &Command{ToReplace: `\d+`, ReplaceWith: `\n`}, true, 9},                               // Other escapes are left alone
+		{`s/C++/Go/l`, &Command{ToReplace: "C++", ReplaceWith: "Go", Flags: Flags{Mode: ModeLiteral}}, true, 10},
+		{`s/a\/b\d/$5\/\1/l`, &Command{ToReplace: `a/b\d`, ReplaceWith: `$5/\1`, Flags: Flags{Mode: ModeLiteral}}, true, 17},
+		{`s#http://a/#https://a/#`, &Command{ToReplace: "http://a/", ReplaceWith: "https://a/"}, true, 23},
+		{"s/a/b/ ;s/c/d/", &Command{ToReplace: "a", ReplaceWith: "b"}, true, 7},
+		{"s/a/b/\nnext line", &Command{ToReplace: "a", ReplaceWith: "b"}, true, 6},
+		{"s/a/b/c/\n", &Command{ToReplace: "a", ReplaceWith: "b/c"}, true, 8},
+		{"3s/a/b/", &Command{Address: Address{Start: LineAddress{Kind: AddressLine, Line: 3}}, ToReplace: "a", ReplaceWith: "b"}, true, 7},
+		{"2,15s/a/b/", &Command{Address: Address{LineAddress{Kind: AddressLine, Line: 2}, LineAddress{Kind: AddressLine, Line: 15}}, ToReplace: "a", ReplaceWith: "b"}, true, 10},
+		{"%s/a/b/", &Command{Address: Address{LineAddress{Kind: AddressLine, Line: 1}, LineAddress{Kind: AddressLast}}, ToReplace: "a", ReplaceWith: "b"}, true, 7},
+		{"$y/a/b/", &Command{Op: OpTransliterate, Address: Address{Start: LineAddress{Kind: AddressLast}}, ToReplace: "a", ReplaceWith: "b"}, true, 7},
+		{"3d ;s/a/b/", &Command{Op: OpDelete, Address: Address{Start: LineAddress{Kind: AddressLine, Line: 3}}}, true, 3},
+		{"$a\\new line", &Command{Op: OpAppend, Address: Address{Start: LineAddress{Kind: AddressLast}}, ReplaceWith: "new line"}, true, 11},
+		{"1i\\\nnew\\\nlines\nafter", &Command{Op: OpInsert, Address: Address{Start: LineAddress{Kind: AddressLine, Line: 1}}, ReplaceWith: "new\nlines"}, true, 14},
+		{`/a/,/b/c\`, &Command{Op: OpChange, Address: Address{LineAddress{Kind: AddressPattern, Pattern: "a"}, LineAddress{Kind: AddressPattern, Pattern: "b"}}}, true, 9},
+		{"s/a/b/?", &Command{ToReplace: "a", ReplaceWith: "b", Flags: Flags{Explain: true}}, true, 7},
+		{"explain  2s/a/b/g", &Command{Address: Address{Start: LineAddress{Kind: AddressLine, Line: 2}}, ToReplace: "a", ReplaceWith: "b", Flags: Flags{Global: true, Explain: true}}, true, 17},
+		{`/a\/b/,$s/a/b/R`, &Command{Address: Address{LineAddress{Kind: AddressPattern, Pattern: "a/b"}, LineAddress{Kind: AddressLast}}, ToReplace: "a", ReplaceWith: "b", Flags: Flags{Mode: ModeGo}}, true, 15},
+	}
+
+	for _, c := range cases {
+		tok := tokenizer{txt: c.input}
+		cmd, closed, err := tok.scanCommand()
+		if err != nil {
+			t.Errorf("scanCommand(%s) should not have errored but did: %s", c.input, err)
+			continue
+		}
+
+		if *cmd != *c.cmd || closed != c.closed || tok.pos != c.end {
+			t.Errorf("scanCommand(%s) should have returned (%+v, %t) ending at %d but returned (%+v, %t) ending at %d", c.input, *c.cmd, c.closed, c.end, *cmd, closed, tok.pos)
+		}
+	}
+}
+
+func TestTokenizerScanCommandErrors(t *testing.T) {
+	cases := []struct {
+		input string
+		pos   int // -1 when the input isn't a command at all
+	}{
+		{"", -1},
+		{"sa/b/", -1},
+		{`s\a\b\`, -1},
+		{"s a b ", -1},
+		{"s/", 2},
+		{"s/abc", 2},
+		{"s/abc\n/d/", 2},
+		{`s/a\/`, 2},
+		{"s/a/b/gz", 7},
+		{"s/a/b/0", 6},
+		{"3", -1},
+		{"3 s/a/b/", -1},
+		{"/a/ s/a/b/", -1},
+		{"2,s/a/b/", -1},
+		{"0s/a/b/", 0},
+		{"1,0s/a/b/", 0},
+		{"explain s a b", -1},
+		{"d", -1},
+		{"3d/", -1},
+		{"3dx", -1},
+		{"a new line", -1},
+		{"c\\foo", -1}, // Line commands have to be addressed
+		{"i\\foo", -1},
+		{"a\\foo", -1},
+		{"explain 1d", 0},
+		{"explain y/a/b/", 0},
+		{"y/a/b/?", 6},
+	}
+
+	for _, c := range cases {
+		tok := tokenizer{txt: c.input}
+		_, _, err := tok.scanCommand()
+		if err == nil {
+			t.Errorf("scanCommand(%s) should have errored but did not", c.input)
+			continue
+		}
+
+		if c.pos < 0 {
+			if !errors.Is(err, ErrNotCommand) {
+				t.Errorf("scanCommand(%s) should have returned ErrNotCommand but returned %s", c.input, err)
+			}
+			continue
+		}
+
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Pos != c.pos {
+			t.Errorf("scanCommand(%s) should have returned a *ParseError at %d but returned %v", c.input, c.pos, err)
+		}
+	}
+}
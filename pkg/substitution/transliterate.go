@@ -0,0 +1,140 @@
+package substitution
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// TransliterationLengthError is returned when the source & destination of a y command hold different numbers of
+// characters
+type TransliterationLengthError struct {
+	Source      int
+	Destination int
+}
+
+func (e *TransliterationLengthError) Error() string {
+	return fmt.Sprintf("source & destination differ in length (%d & %d characters)", e.Source, e.Destination)
+}
+
+// parseTransliterationFlags parses the flags of a y command. Only the markdown flags apply to it.
+func parseTransliterationFlags(txt string) (Flags, error) {
+	for i := 0; i < len(txt); i++ {
+		if !strings.ContainsRune("mcu", rune(txt[i])) {
+			return Flags{}, &FlagError{txt, i, fmt.Sprintf("flag %q doesn't apply to y", txt[i])}
+		}
+	}
+
+	return ParseFlags(txt)
+}
+
+// unescapeTransliteration decodes the characters of one side of a y command. Like sed, \n, \t & \\ stand for a
+// newline, tab & backslash while any other escaped character is taken literally.
+func unescapeTransliteration(txt string) []rune {
+	runes := []rune{}
+	escaped := false
+	for _, r := range txt {
+		switch {
+		case escaped && r == 'n':
+			runes = append(runes, '\n')
+		case escaped && r == 't':
+			runes = append(runes, '\t')
+		case escaped:
+			runes = append(runes, r)
+		case r == '\\':
+			escaped = true
+			continue
+		default:
+			runes = append(runes, r)
+		}
+		escaped = false
+	}
+
+	if escaped {
+		runes = append(runes, '\\')
+	}
+
+	return runes
+}
+
+// compileTransliteration maps each character of ToReplace to the one at the same position of ReplaceWith. When a
+// character is given more than once its first mapping is used.
+func (s *Command) compileTransliteration() (map[rune]rune, error) {
+	source := unescapeTransliteration(s.ToReplace)
+	destination := unescapeTransliteration(s.ReplaceWith)
+	if len(source) != len(destination) {
+		return nil, &TransliterationLengthError{len(source), len(destination)}
+	}
+
+	mapping := make(map[rune]rune, len(source))
+	for i, r := range source {
+		if _, ok := mapping[r]; !ok {
+			mapping[r] = destination[i]
+		}
+	}
+
+	return mapping, nil
+}
+
+// transliterate applies a y command, replacing every character of ToReplace throughout txt
+func (s *Command) transliterate(txt string, limits *Limits) (*Result, error) {
+	if err := limits.checkPatternLength(s.ToReplace); err != nil {
+		return nil, err
+	}
+
+	mapping, err := s.compileTransliteration()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := [][]int{}
+	for i, r := range txt {
+		if _, ok := mapping[r]; ok {
+			// Invalid bytes decode to utf8.RuneError but are only a byte wide
+			_, size := utf8.DecodeRuneInString(txt[i:])
+			matches = append(matches, []int{i, i + size})
+		}
+	}
+
+	if s.Flags.Markdown {
+		scope := markdownScope{parseMarkdownNodes(txt), s.Flags.IncludeCode, s.Flags.IncludeLinks}
+		matches = scope.filter(matches)
+	}
+
+	groups, err := s.groupMatchesByLine(txt, matches, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	matches = nil
+	for _, group := range groups {
+		matches = append(matches, group...)
+	}
+
+	// Characters aren't counted against MaxReplacements since y can't lengthen its input, leaving MaxOutputLength to
+	// bound it
+	result := &Result{}
+	out := strings.Builder{}
+	last := 0
+	for _, m := range matches {
+		out.WriteString(txt[last:m[0]])
+		start := out.Len()
+		r, _ := utf8.DecodeRuneInString(txt[m[0]:])
+		out.WriteRune(mapping[r])
+		result.addChange(Change{start, out.Len(), txt[m[0]:m[1]]})
+		last = m[1]
+	}
+	out.WriteString(txt[last:])
+
+	result.Output = out.String()
+	if err := limits.CheckOutputLength(result.Output); err != nil {
+		return nil, err
+	}
+
+	if result.Output == txt {
+		return nil, ErrNoChange
+	}
+	result.dropUnchanged()
+
+	return result, nil
+}
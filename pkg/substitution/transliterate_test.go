@@ -0,0 +1,89 @@
+package substitution
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseTransliteration(t *testing.T) {
+	cases := []struct {
+		input string
+		cmd   *Command
+		err   bool
+	}{
+		{"y/abc/xyz/", &Command{Op: OpTransliterate, ToReplace: "abc", ReplaceWith: "xyz"}, false},
+		{"y|a\\|b|x\\|y|", &Command{Op: OpTransliterate, ToReplace: "a|b", ReplaceWith: "x|y"}, false},
+		{"y/aeiou/eioua", &Command{Op: OpTransliterate, ToReplace: "aeiou", ReplaceWith: "eioua"}, false}, // Unclosed
+		{"y/ab/x/", nil, true},   // Different lengths
+		{"y/ab/xy/g", nil, true}, // Flag doesn't apply
+		{"y/ab/xy/m", &Command{Op: OpTransliterate, ToReplace: "ab", ReplaceWith: "xy", Flags: Flags{Markdown: true}}, false},
+		{"y/é\\n/e\\\\/", &Command{Op: OpTransliterate, ToReplace: "é\\n", ReplaceWith: "e\\\\"}, false}, // Escapes count as a single character
+	}
+
+	for _, c := range cases {
+		out, err := ParseSubstitutionCommand(c.input)
+		if c.err {
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Errorf("ParseSubstitutionCommand(%q) should have returned a *ParseError but returned %v", c.input, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseSubstitutionCommand(%q) should not have errored but did: %s", c.input, err)
+			continue
+		}
+
+		if *out != *c.cmd {
+			t.Errorf("ParseSubstitutionCommand(%q) should have returned %+v but returned %+v", c.input, *c.cmd, *out)
+		}
+	}
+}
+
+func TestTransliterationApply(t *testing.T) {
+	cases := []struct {
+		in      string
+		cmd     Command
+		out     string
+		changes []Change
+		err     bool
+	}{
+		{"banana", Command{Op: OpTransliterate, ToReplace: "an", ReplaceWith: "oa"}, "boaoao", []Change{{1, 6, "anana"}}, false},
+		{"hello", Command{Op: OpTransliterate, ToReplace: "aeiou", ReplaceWith: "eioua"}, "hillu", []Change{{1, 2, "e"}, {4, 5, "o"}}, false},
+		{"ab ba", Command{Op: OpTransliterate, ToReplace: "ab", ReplaceWith: "ba"}, "ba ab", []Change{{0, 2, "ab"}, {3, 5, "ba"}}, false},
+		{"naïve", Command{Op: OpTransliterate, ToReplace: "ï", ReplaceWith: "i"}, "naive", []Change{{2, 3, "ï"}}, false},
+		{"a\nb", Command{Op: OpTransliterate, ToReplace: `\n`, ReplaceWith: " "}, "a b", []Change{{1, 2, "\n"}}, false},
+		{"\xff", Command{Op: OpTransliterate, ToReplace: "\x800", ReplaceWith: "00"}, "0", []Change{{0, 1, "\xff"}}, false}, // Invalid bytes
+		{"a `a`", Command{Op: OpTransliterate, ToReplace: "a", ReplaceWith: "b", Flags: Flags{Markdown: true}}, "b `a`", []Change{{0, 1, "a"}}, false},
+		{"aa", Command{Op: OpTransliterate, ToReplace: "aa", ReplaceWith: "ab"}, "", nil, true}, // First mapping wins so nothing changes
+		{"xyz", Command{Op: OpTransliterate, ToReplace: "a", ReplaceWith: "b"}, "", nil, true},
+		{"abc", Command{Op: OpTransliterate, ToReplace: "ab", ReplaceWith: "b"}, "", nil, true},
+	}
+
+	for _, c := range cases {
+		result, err := c.cmd.Apply(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("%+v.Apply(%q) should have errored but did not", c.cmd, c.in)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%+v.Apply(%q) should not have errored but did: %s", c.cmd, c.in, err)
+			continue
+		}
+
+		if result.Output != c.out || len(result.Changes) != len(c.changes) {
+			t.Errorf("%+v.Apply(%q) should have returned %q with %+v but returned %q with %+v", c.cmd, c.in, c.out, c.changes, result.Output, result.Changes)
+			continue
+		}
+
+		for i := range c.changes {
+			if result.Changes[i] != c.changes[i] {
+				t.Errorf("%+v.Apply(%q) change %d should have been %+v but was %+v", c.cmd, c.in, i, c.changes[i], result.Changes[i])
+			}
+		}
+	}
+}
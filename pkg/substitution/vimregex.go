@@ -0,0 +1,480 @@
+package substitution
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// UnsupportedConstructError is returned when a VIM pattern uses a construct that can't be expressed in RE2
+type UnsupportedConstructError struct {
+	Construct   string
+	Description string
+	Offset      int
+}
+
+func (e *UnsupportedConstructError) Error() string {
+	return fmt.Sprintf("%s (%s) at offset %d is not supported", e.Construct, e.Description, e.Offset)
+}
+
+type vimMagic int
+
+const (
+	vimVeryNoMagic  vimMagic = iota // \V
+	vimNoMagic                      // \M
+	vimMagicDefault                 // \m
+	vimVeryMagic                    // \v
+)
+
+// Characters whose meaning depends on whether they're escaped & the current magic level
+const vimMagicCharacters = `()|+?={@<>%&~.*[^$`
+
+// Characters from vimMagicCharacters that are special without a backslash at each magic level
+var vimUnescapedSpecials = map[vimMagic]string{
+	vimVeryNoMagic:  ``,
+	vimNoMagic:      `^$`,
+	vimMagicDefault: `.*[~^$`,
+	vimVeryMagic:    vimMagicCharacters,
+}
+
+// vimClass is a character class such as \d or \S
+type vimClass struct {
+	set     string
+	negated bool
+}
+
+var vimClasses = map[byte]vimClass{
+	's': {` \t`, false}, 'S': {` \t`, true},
+	'd': {`0-9`, false}, 'D': {`0-9`, true},
+	'w': {`0-9A-Za-z_`, false}, 'W': {`0-9A-Za-z_`, true},
+	'h': {`A-Za-z_`, false}, 'H': {`A-Za-z_`, true},
+	'a': {`A-Za-z`, false}, 'A': {`A-Za-z`, true},
+	'l': {`a-z`, false}, 'L': {`a-z`, true},
+	'u': {`A-Z`, false}, 'U': {`A-Z`, true},
+	'x': {`0-9A-Fa-f`, false}, 'X': {`0-9A-Fa-f`, true},
+	'o': {`0-7`, false}, 'O': {`0-7`, true},
+}
+
+// render converts the class into RE2. VIM patterns never match a line break unless asked to with \_, so negated
+// classes exclude it (& \_ classes include it).
+func (c vimClass) render(withNewline bool) string {
+	switch {
+	case c.negated && withNewline:
+		return `[^` + c.set + `]`
+	case c.negated:
+		return `[^` + c.set + `\n]`
+	case withNewline:
+		return `[` + c.set + `\n]`
+	default:
+		return `[` + c.set + `]`
+	}
+}
+
+var vimCharacterEscapes = map[byte]string{
+	'n': `\n`,
+	't': `\t`,
+	'r': `\r`,
+	'e': `\x1b`,
+	'b': `\x08`,
+}
+
+// vimToken is a single (possibly escaped) character of a VIM pattern
+type vimToken struct {
+	r       rune
+	escaped bool
+	offset  int
+}
+
+type vimTranslator struct {
+	pattern     string
+	pos         int
+	magic       vimMagic
+	out         strings.Builder
+	prefix      string
+	branchStart bool
+}
+
+func (t *vimTranslator) unsupported(offset int, end int, description string) error {
+	return &UnsupportedConstructError{t.pattern[offset:end], description, offset}
+}
+
+func (t *vimTranslator) done() bool {
+	return t.pos >= len(t.pattern)
+}
+
+func (t *vimTranslator) next() vimToken {
+	tok := vimToken{offset: t.pos}
+	if t.pattern[t.pos] == '\\' && t.pos+1 < len(t.pattern) {
+		tok.escaped = true
+		t.pos++
+	}
+
+	r, size := utf8.DecodeRuneInString(t.pattern[t.pos:])
+	tok.r = r
+	t.pos += size
+	return tok
+}
+
+func (t *vimTranslator) peek() (vimToken, bool) {
+	if t.done() {
+		return vimToken{}, false
+	}
+
+	pos := t.pos
+	tok := t.next()
+	t.pos = pos
+	return tok, true
+}
+
+// special reports whether tok has a special meaning at the current magic level
+func (t *vimTranslator) special(tok vimToken) bool {
+	if tok.r >= utf8.RuneSelf || !strings.ContainsRune(vimMagicCharacters, tok.r) {
+		return false
+	}
+
+	return tok.escaped != strings.ContainsRune(vimUnescapedSpecials[t.magic], tok.r)
+}
+
+func (t *vimTranslator) emit(s string) {
+	t.out.WriteString(s)
+	t.branchStart = false
+}
+
+func (t *vimTranslator) emitLiteral(r rune) {
+	t.emit(regexp.QuoteMeta(string(r)))
+}
+
+// translateMulti translates the repetition operators, which VIM takes literally when there's nothing to repeat
+func (t *vimTranslator) translateMulti(tok vimToken) error {
+	if t.branchStart {
+		t.emitLiteral(tok.r)
+		return nil
+	}
+
+	switch tok.r {
+	case '{':
+		return t.translateBrace(tok.offset)
+	case '=', '?':
+		t.emit("?")
+	default:
+		t.emit(string(tok.r))
+	}
+
+	return nil
+}
+
+// translateBrace translates the count in \{n,m} (the opening brace has already been consumed)
+func (t *vimTranslator) translateBrace(offset int) error {
+	end := strings.IndexByte(t.pattern[t.pos:], '}')
+	if end < 0 {
+		return t.unsupported(offset, len(t.pattern), "unterminated count")
+	}
+
+	count := strings.TrimSuffix(t.pattern[t.pos:t.pos+end], `\`)
+	t.pos += end + 1
+
+	lazy := strings.HasPrefix(count, "-")
+	count = strings.TrimPrefix(count, "-")
+
+	bounds := strings.Split(count, ",")
+	for _, b := range bounds {
+		if _, err := strconv.Atoi(b); err != nil && len(b) > 0 {
+			return t.unsupported(offset, t.pos, "invalid count")
+		}
+	}
+
+	var repetition string
+	switch {
+	case len(bounds) > 2:
+		return t.unsupported(offset, t.pos, "invalid count")
+	case len(count) == 0 || count == ",":
+		repetition = "*"
+	case len(bounds) == 1:
+		repetition = "{" + bounds[0] + "}"
+	case len(bounds[0]) == 0:
+		repetition = "{0," + bounds[1] + "}"
+	default:
+		repetition = "{" + bounds[0] + "," + bounds[1] + "}"
+	}
+
+	if lazy {
+		repetition += "?"
+	}
+	t.emit(repetition)
+	return nil
+}
+
+// translateCollection translates a [] collection (the opening bracket has already been consumed). If there's no
+// closing bracket, VIM takes the [ literally.
+func (t *vimTranslator) translateCollection(withNewline bool) {
+	start := t.pos
+	collection := strings.Builder{}
+	collection.WriteString("[")
+
+	negated := strings.HasPrefix(t.pattern[t.pos:], "^")
+	if negated {
+		collection.WriteString("^")
+		t.pos++
+	}
+
+	if strings.HasPrefix(t.pattern[t.pos:], "]") {
+		collection.WriteString(`\]`)
+		t.pos++
+	}
+
+	for !t.done() {
+		rest := t.pattern[t.pos:]
+		switch {
+		case rest[0] == ']':
+			t.pos++
+			if negated && !withNewline {
+				collection.WriteString(`\n`)
+			} else if !negated && withNewline {
+				collection.WriteString(`\n`)
+			}
+			collection.WriteString("]")
+			t.emit(collection.String())
+			return
+		case strings.HasPrefix(rest, "[:"):
+			end := strings.Index(rest, ":]")
+			if end < 0 {
+				collection.WriteString(`\[`)
+				t.pos++
+				continue
+			}
+			collection.WriteString(rest[:end+2])
+			t.pos += end + 2
+		case rest[0] == '\\' && len(rest) > 1:
+			if escape, ok := vimCharacterEscapes[rest[1]]; ok {
+				collection.WriteString(escape)
+			} else if strings.IndexByte(`\]^-`, rest[1]) >= 0 {
+				collection.WriteString(rest[:2])
+			} else {
+				// Any other backslash is literal inside a collection
+				collection.WriteString(`\\`)
+				t.pos++
+				continue
+			}
+			t.pos += 2
+		case rest[0] == '[':
+			collection.WriteString(`\[`)
+			t.pos++
+		default:
+			_, size := utf8.DecodeRuneInString(rest)
+			collection.WriteString(rest[:size])
+			t.pos += size
+		}
+	}
+
+	t.pos = start
+	t.emit(`\[`)
+}
+
+// translatePercent translates the \% family of constructs (the % has already been consumed)
+func (t *vimTranslator) translatePercent(offset int) error {
+	if t.done() {
+		return t.unsupported(offset, t.pos, "incomplete \\% item")
+	}
+
+	rest := t.pattern[t.pos:]
+	switch rest[0] {
+	case '(':
+		t.pos++
+		t.emit("(?:")
+		t.branchStart = true
+		return nil
+	case '^':
+		t.pos++
+		t.emit(`\A`)
+		return nil
+	case '$':
+		t.pos++
+		t.emit(`\z`)
+		return nil
+	case 'd', 'x', 'o', 'u', 'U':
+		base, digits := 16, "0123456789abcdefABCDEF"
+		if rest[0] == 'd' {
+			base, digits = 10, "0123456789"
+		} else if rest[0] == 'o' {
+			base, digits = 8, "01234567"
+		}
+
+		end := 1
+		for end < len(rest) && strings.IndexByte(digits, rest[end]) >= 0 {
+			end++
+		}
+
+		code, err := strconv.ParseInt(rest[1:end], base, 32)
+		t.pos += end
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return t.unsupported(offset, t.pos, "invalid character code")
+		}
+
+		t.emitLiteral(rune(code))
+		return nil
+	case '[':
+		return t.unsupported(offset, t.pos+1, "optional sequence")
+	default:
+		return t.unsupported(offset, t.pos+1, "position or mark match")
+	}
+}
+
+func (t *vimTranslator) translateSpecial(tok vimToken) error {
+	switch tok.r {
+	case '(':
+		t.emit("(")
+		t.branchStart = true
+	case ')':
+		t.emit(")")
+	case '|':
+		t.emit("|")
+		t.branchStart = true
+	case '+', '*', '=', '?', '{':
+		return t.translateMulti(tok)
+	case '<', '>':
+		// Always adjacent to a word character in practice, where \b is equivalent. Engines check it against Unicode
+		// words (see wordNFA).
+		t.emit(`\b`)
+	case '.':
+		t.emit(".")
+	case '[':
+		t.translateCollection(false)
+	case '^':
+		if !t.branchStart {
+			t.emitLiteral(tok.r)
+			break
+		}
+		t.emit("(?m:^)")
+	case '$':
+		next, ok := t.peek()
+		if ok && !(t.special(next) && (next.r == '|' || next.r == ')')) {
+			t.emitLiteral(tok.r)
+			break
+		}
+		t.emit("(?m:$)")
+	case '%':
+		return t.translatePercent(tok.offset)
+	case '@':
+		end := t.pos
+		for end < len(t.pattern) && strings.IndexByte("0123456789<=!>", t.pattern[end]) >= 0 {
+			end++
+		}
+		return t.unsupported(tok.offset, end, "lookaround")
+	case '&':
+		return t.unsupported(tok.offset, t.pos, "branch concatenation")
+	case '~':
+		// Stands for the last substitute string in VIM, which the bot never has, so it's simply a tilde
+		t.emitLiteral('~')
+	}
+
+	return nil
+}
+
+func (t *vimTranslator) translateEscape(tok vimToken) error {
+	if tok.r >= utf8.RuneSelf {
+		t.emitLiteral(tok.r)
+		return nil
+	}
+
+	c := byte(tok.r)
+	if class, ok := vimClasses[c]; ok {
+		t.emit(class.render(false))
+		return nil
+	}
+
+	if escape, ok := vimCharacterEscapes[c]; ok {
+		t.emit(escape)
+		return nil
+	}
+
+	switch {
+	case c == 'v':
+		t.magic = vimVeryMagic
+	case c == 'm':
+		t.magic = vimMagicDefault
+	case c == 'M':
+		t.magic = vimNoMagic
+	case c == 'V':
+		t.magic = vimVeryNoMagic
+	case c == 'c':
+		t.prefix = "(?i)"
+	case c == 'C':
+		t.prefix = "(?-i)"
+	case c >= '0' && c <= '9':
+		return t.unsupported(tok.offset, t.pos, "backreference")
+	case c == 'z':
+		if !t.done() {
+			t.pos++
+		}
+		return t.unsupported(tok.offset, t.pos, "\\z item")
+	case c == '_':
+		return t.translateUnderscore(tok.offset)
+	case strings.IndexByte("iIkKfFpP", c) >= 0:
+		return t.unsupported(tok.offset, t.pos, "option dependent character class")
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return t.unsupported(tok.offset, t.pos, "unknown escape")
+	default:
+		t.emitLiteral(tok.r)
+	}
+
+	return nil
+}
+
+// translateUnderscore translates \_x items, which are like x but also match a line break
+func (t *vimTranslator) translateUnderscore(offset int) error {
+	if t.done() {
+		return t.unsupported(offset, t.pos, "incomplete \\_ item")
+	}
+
+	c := t.pattern[t.pos]
+	t.pos++
+
+	if class, ok := vimClasses[c]; ok {
+		t.emit(class.render(true))
+		return nil
+	}
+
+	switch c {
+	case '.':
+		t.emit("(?s:.)")
+	case '^':
+		t.emit("(?m:^)")
+	case '$':
+		t.emit("(?m:$)")
+	case '[':
+		t.translateCollection(true)
+	default:
+		return t.unsupported(offset, t.pos, "unknown \\_ item")
+	}
+
+	return nil
+}
+
+// TranslateVimPattern converts a VIM pattern into an equivalent RE2 (Go regexp) pattern. The pattern starts out
+// 'magic' & \v, \m, \M & \V switch between very magic, magic, nomagic & very nomagic. Constructs RE2 can't express,
+// such as backreferences & lookarounds, result in an *UnsupportedConstructError.
+func TranslateVimPattern(pattern string) (string, error) {
+	t := vimTranslator{pattern: pattern, magic: vimMagicDefault, branchStart: true}
+
+	for !t.done() {
+		tok := t.next()
+
+		var err error
+		switch {
+		case t.special(tok):
+			err = t.translateSpecial(tok)
+		case tok.escaped:
+			err = t.translateEscape(tok)
+		default:
+			t.emitLiteral(tok.r)
+		}
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return t.prefix + t.out.String(), nil
+}
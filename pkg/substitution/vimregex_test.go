@@ -0,0 +1,92 @@
+package substitution
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestTranslateVimPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		re2     string
+	}{
+		{`abc`, `abc`},
+		{`a.c*`, `a.c*`},
+		{`a+b?(c)|{d}`, `a\+b\?\(c\)\|\{d\}`},
+		{`\(a\|b\)\+\=`, `(a|b)+?`},
+		{`\%(a\)`, `(?:a)`},
+		{`a\{2,3}b\{-1,}c\{}d\{,4\}e\{3}`, `a{2,3}b{1,}?c*d{0,4}e{3}`},
+		{`\<word\>`, `\bword\b`},
+		{`\d\D\s\S\w\W`, `[0-9][^0-9\n][ \t][^ \t\n][0-9A-Za-z_][^0-9A-Za-z_\n]`},
+		{`\_s\_.\_S`, `[ \t\n](?s:.)[^ \t]`},
+		{`^a$`, `(?m:^)a(?m:$)`},
+		{`a^b$c`, `a\^b\$c`},
+		{`\(^a$\|^b$\)`, `((?m:^)a(?m:$)|(?m:^)b(?m:$))`},
+		{`*a`, `\*a`},
+		{`[abc][^a-z]`, `[abc][^a-z\n]`},
+		{`[]a][[:alpha:]]\_[^x]`, `[\]a][[:alpha:]][^x]`},
+		{`[abc`, `\[abc`},
+		{`a\/b\\c\.`, `a/b\\c\.`},
+		{`\%d47\%x41\%u20AC`, `/A€`},
+		{`\%^a\%$`, `\Aa\z`},
+		{`\v(a|b)+<c>{2}=`, `(a|b)+\bc\b{2}?`},
+		{`\v\(a\)`, `\(a\)`},
+		{`\Ma.*\.\*`, `a\.\*.*`},
+		{`\Va.b\.$`, `a\.b.\$`},
+		{`\ca`, `(?i)a`},
+		{`a\n\t`, `a\n\t`},
+		{`ünï.`, `ünï.`},
+		{`a~`, `a~`}, // There's no last substitute string so ~ is literal
+		{`\va~\~`, `a~~`},
+	}
+
+	for _, c := range cases {
+		out, err := TranslateVimPattern(c.pattern)
+		if err != nil {
+			t.Errorf("TranslateVimPattern(%s) should not have errored but did: %s", c.pattern, err)
+			continue
+		}
+
+		if out != c.re2 {
+			t.Errorf("TranslateVimPattern(%s) should have returned %s but returned %s", c.pattern, c.re2, out)
+		}
+
+		if _, err := regexp.Compile(out); err != nil {
+			t.Errorf("TranslateVimPattern(%s) returned %s which doesn't compile: %s", c.pattern, out, err)
+		}
+	}
+}
+
+func TestTranslateVimPatternUnsupported(t *testing.T) {
+	cases := []struct {
+		pattern   string
+		construct string
+		offset    int
+	}{
+		{`\(a\)\1`, `\1`, 5},
+		{`foo\(bar\)\@=`, `\@=`, 10},
+		{`\(foo\)\@<!bar`, `\@<!`, 7},
+		{`\v(foo)@<=bar`, `@<=`, 7},
+		{`foo\zsbar`, `\zs`, 3},
+		{`a\&b`, `\&`, 1},
+		{`\%[abc]`, `\%[`, 0},
+		{`\%23l`, `\%2`, 0},
+		{`\k`, `\k`, 0},
+		{`\q`, `\q`, 0},
+	}
+
+	for _, c := range cases {
+		_, err := TranslateVimPattern(c.pattern)
+
+		var unsupported *UnsupportedConstructError
+		if !errors.As(err, &unsupported) {
+			t.Errorf("TranslateVimPattern(%s) should have returned an *UnsupportedConstructError but returned %v", c.pattern, err)
+			continue
+		}
+
+		if unsupported.Construct != c.construct || unsupported.Offset != c.offset {
+			t.Errorf("TranslateVimPattern(%s) should have errored on %s at %d but errored on %s at %d", c.pattern, c.construct, c.offset, unsupported.Construct, unsupported.Offset)
+		}
+	}
+}
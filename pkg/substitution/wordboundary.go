@@ -0,0 +1,39 @@
+package substitution
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// isWordRune reports whether r is part of a word. Unlike RE2's ASCII only \b, letters, digits & combining marks of
+// any script count (as does _ like in \w).
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// atWordBoundary reports whether pos in txt lies between a word character & a non word character (or an end of txt)
+func atWordBoundary(txt string, pos int) bool {
+	before, after := false, false
+	if pos > 0 {
+		r, _ := utf8.DecodeLastRuneInString(txt[:pos])
+		before = isWordRune(r)
+	}
+
+	if pos < len(txt) {
+		r, _ := utf8.DecodeRuneInString(txt[pos:])
+		after = isWordRune(r)
+	}
+
+	return before != after
+}
+
+// hasNonASCIIWords reports whether txt holds word characters outside ASCII, the only text where RE2's \b disagrees
+// with atWordBoundary
+func hasNonASCIIWords(txt string) bool {
+	for _, r := range txt {
+		if r >= utf8.RuneSelf && isWordRune(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package substitution
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestAtWordBoundary(t *testing.T) {
+	cases := []struct {
+		txt      string
+		pos      int
+		boundary bool
+	}{
+		{"café", 0, true},
+		{"café", 5, true},
+		{"café", 3, false},
+		{"a b", 1, true},
+		{"a_b", 1, false},
+		{"добрый день", 12, true},
+		{"नमस्ते", 6, false}, // Combining vowel signs are part of the word
+		{"", 0, false},
+	}
+
+	for _, c := range cases {
+		if boundary := atWordBoundary(c.txt, c.pos); boundary != c.boundary {
+			t.Errorf("atWordBoundary(%q, %d) should have returned %t but returned %t", c.txt, c.pos, c.boundary, boundary)
+		}
+	}
+}
+
+func TestWordNFAFindAll(t *testing.T) {
+	cases := []struct {
+		pattern string
+		in      string
+		matches [][]int
+	}{
+		{`\bcafé\b`, "cafés café", [][]int{{7, 12}}},
+		{`(a|ab)\b`, "ab xy", [][]int{{0, 2, 0, 2}}}, // Alternatives failing a word boundary give way to the next
+		{`(é|éa)\b`, "éa xy", [][]int{{0, 3, 0, 3}}},
+		{`café(s)?\b`, "cafés café", [][]int{{0, 6, 5, 6}, {7, 12, -1, -1}}},
+		{`é\B.`, "éa é-", [][]int{{0, 3}}},
+		{`(\bça )+`, "ça ça ", [][]int{{0, 8, 4, 8}}},
+		{`\b`, "é é", [][]int{{0, 0}, {2, 2}, {3, 3}, {5, 5}}},
+		{`^\bé|x`, "éé\né", [][]int{{0, 2}}},
+		{`(?m:^)\bé`, "éé\né", [][]int{{0, 2}, {5, 7}}},
+		{`(x)?\bé`, "-é", [][]int{{1, 3, -1, -1}}},
+	}
+
+	for _, c := range cases {
+		nfa := compileWordNFA(c.pattern)
+		if nfa == nil {
+			t.Errorf("compileWordNFA(%s) should have returned a *wordNFA but returned nil", c.pattern)
+			continue
+		}
+
+		if matches := nfa.findAll(c.in, -1); !reflect.DeepEqual(matches, c.matches) {
+			t.Errorf("compileWordNFA(%s).findAll(%q) should have returned %v but returned %v", c.pattern, c.in, c.matches, matches)
+		}
+	}
+
+	if nfa := compileWordNFA(`café`); nfa != nil {
+		t.Errorf("compileWordNFA(café) should have returned nil without word boundaries but returned %v", nfa)
+	}
+}
+
+// Without words outside ASCII, a wordNFA should find exactly what the regexp package does
+func TestWordNFAMatchesRegexp(t *testing.T) {
+	patterns := []string{`\b\w+\b`, `(a|ab)(c|bcd)?\b`, `\Bx*`, `(?i)\b(?:th(e)|a)\b`, `\b`, `x*`, `(?m:^)\b.|$`}
+	inputs := []string{"", "abcd ab a", "the cat, THE hat", "xx yxx\nzx", "__ -- 12ab"}
+
+	for _, pattern := range patterns {
+		nfa := compileWordNFA(pattern + `|\b\B`)
+		re := regexp.MustCompile(pattern + `|\b\B`)
+		for _, in := range inputs {
+			for _, n := range []int{-1, 1, 2} {
+				if matches, expected := nfa.findAll(in, n), re.FindAllStringSubmatchIndex(in, n); !reflect.DeepEqual(matches, expected) {
+					t.Errorf("compileWordNFA(%s).findAll(%q, %d) should have returned %v but returned %v", pattern, in, n, expected, matches)
+				}
+			}
+		}
+	}
+}
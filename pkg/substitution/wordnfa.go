@@ -0,0 +1,313 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// The Go LICENSE is reproduced in GO_LICENSE next to this file.
+
+// This file is adapted from the NFA (Pike VM) of src/regexp/exec.go & the match loop of allMatches in
+// src/regexp/regexp.go as of Go 1.27.1, and should be kept in step with them. The only intended difference is that
+// \b & \B are checked with atWordBoundary rather than RE2's ASCII only rule, which lives in contextAt.
+
+package substitution
+
+import (
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// wordNFA runs a compiled RE2 program the way the regexp package's NFA does (finding the same leftmost-first
+// matches in linear time) except that \b & \B are checked with atWordBoundary. Being slower than the regexp package,
+// it's only used for text where the two disagree.
+type wordNFA struct {
+	prog *syntax.Prog
+	ncap int // number of submatch indexes in a match (two per group, including the whole match)
+}
+
+// compileWordNFA compiles pattern the same way the regexp package does, returning nil when it holds no word
+// boundaries (or can't be compiled)
+func compileWordNFA(pattern string) *wordNFA {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	ncap := 2 * (re.MaxCap() + 1)
+
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil
+	}
+
+	for _, inst := range prog.Inst {
+		if inst.Op == syntax.InstEmptyWidth && syntax.EmptyOp(inst.Arg)&(syntax.EmptyWordBoundary|syntax.EmptyNoWordBoundary) != 0 {
+			return &wordNFA{prog, ncap}
+		}
+	}
+	return nil
+}
+
+// findAll returns the submatch indexes of up to n matches in txt (all of them when n < 0). Like the regexp package,
+// an empty match directly after another match is skipped.
+func (w *wordNFA) findAll(txt string, n int) [][]int {
+	if n < 0 {
+		n = len(txt) + 1
+	}
+
+	m := newNFAMachine(w, txt)
+	var matches [][]int
+	for pos, prevEnd := 0, -1; len(matches) < n && pos <= len(txt); {
+		match := m.match(pos)
+		if match == nil {
+			break
+		}
+
+		accept := true
+		if match[1] == pos {
+			accept = match[0] != prevEnd
+			_, width := utf8.DecodeRuneInString(txt[pos:])
+			if width == 0 {
+				width = 1
+			}
+			pos += width
+		} else {
+			pos = match[1]
+		}
+		prevEnd = match[1]
+
+		if accept {
+			matches = append(matches, match)
+		}
+	}
+
+	return matches
+}
+
+// nfaThread is a thread of execution waiting at a consuming instruction (or a match) with the captures made so far
+type nfaThread struct {
+	inst *syntax.Inst
+	cap  []int
+}
+
+// nfaQueue holds the threads at one position in order of priority, visiting each instruction at most once. sparse
+// & dense form a set of the instructions visited.
+type nfaQueue struct {
+	sparse []uint32
+	dense  []nfaEntry
+}
+
+type nfaEntry struct {
+	pc uint32
+	t  *nfaThread
+}
+
+func newNFAQueue(n int) *nfaQueue {
+	return &nfaQueue{make([]uint32, n), make([]nfaEntry, 0, n)}
+}
+
+func (q *nfaQueue) contains(pc uint32) bool {
+	j := q.sparse[pc]
+	return j < uint32(len(q.dense)) && q.dense[j].pc == pc
+}
+
+// nfaMachine holds the state of a wordNFA matching one text
+type nfaMachine struct {
+	nfa      *wordNFA
+	txt      string
+	runq     *nfaQueue
+	nextq    *nfaQueue
+	pool     []*nfaThread
+	matched  bool
+	matchcap []int
+}
+
+func newNFAMachine(w *wordNFA, txt string) *nfaMachine {
+	n := len(w.prog.Inst)
+	return &nfaMachine{nfa: w, txt: txt, runq: newNFAQueue(n), nextq: newNFAQueue(n), matchcap: make([]int, w.prog.NumCap)}
+}
+
+func (m *nfaMachine) alloc(inst *syntax.Inst) *nfaThread {
+	if n := len(m.pool); n > 0 {
+		t := m.pool[n-1]
+		m.pool = m.pool[:n-1]
+		t.inst = inst
+		return t
+	}
+	return &nfaThread{inst, make([]int, len(m.matchcap))}
+}
+
+// clear returns the threads of q to the pool
+func (m *nfaMachine) clear(q *nfaQueue) {
+	for _, d := range q.dense {
+		if d.t != nil {
+			m.pool = append(m.pool, d.t)
+		}
+	}
+	q.dense = q.dense[:0]
+}
+
+// runeAt returns the rune at pos & its width (-1 & 0 at the end of the text). Invalid bytes are a byte wide.
+func (m *nfaMachine) runeAt(pos int) (rune, int) {
+	if pos >= len(m.txt) {
+		return -1, 0
+	}
+	return utf8.DecodeRuneInString(m.txt[pos:])
+}
+
+// contextAt returns the empty width assertions that hold at pos
+func (m *nfaMachine) contextAt(pos int) syntax.EmptyOp {
+	before := rune(-1)
+	if pos > 0 {
+		before, _ = utf8.DecodeLastRuneInString(m.txt[:pos])
+	}
+	after, _ := m.runeAt(pos)
+
+	context := syntax.EmptyOpContext(before, after) &^ (syntax.EmptyWordBoundary | syntax.EmptyNoWordBoundary)
+	if atWordBoundary(m.txt, pos) {
+		return context | syntax.EmptyWordBoundary
+	}
+	return context | syntax.EmptyNoWordBoundary
+}
+
+// match returns the submatch indexes of the leftmost-first match at or after pos (nil when there's none)
+func (m *nfaMachine) match(pos int) []int {
+	prog := m.nfa.prog
+	startCond := prog.StartCond()
+	if startCond == ^syntax.EmptyOp(0) {
+		return nil
+	}
+	anchored := startCond&syntax.EmptyBeginText != 0
+
+	m.matched = false
+	for i := range m.matchcap {
+		m.matchcap[i] = -1
+	}
+
+	runq, nextq := m.runq, m.nextq
+	r, width := m.runeAt(pos)
+	context := m.contextAt(pos)
+	for {
+		if len(runq.dense) == 0 && (m.matched || (anchored && pos != 0)) {
+			break
+		}
+
+		// New threads start at every position until a match is found, with a lower priority than those before them
+		if !m.matched && (!anchored || pos == 0) {
+			m.matchcap[0] = pos
+			m.add(runq, uint32(prog.Start), pos, m.matchcap, context, nil)
+		}
+
+		nextPos := pos + width
+		nextContext := m.contextAt(nextPos)
+		m.step(runq, nextq, pos, nextPos, r, nextContext)
+		if width == 0 {
+			break
+		}
+
+		pos, context = nextPos, nextContext
+		r, width = m.runeAt(pos)
+		runq, nextq = nextq, runq
+	}
+	m.clear(runq)
+	m.clear(nextq)
+
+	if !m.matched {
+		return nil
+	}
+
+	match := make([]int, m.nfa.ncap)
+	for i := range match {
+		match[i] = -1
+	}
+	copy(match, m.matchcap)
+	return match
+}
+
+// add follows the empty transitions from pc at pos, queueing a thread at each consuming instruction reached. cap
+// holds the captures made so far & t is a spare thread to reuse, which is returned when it wasn't needed.
+func (m *nfaMachine) add(q *nfaQueue, pc uint32, pos int, cap []int, context syntax.EmptyOp, t *nfaThread) *nfaThread {
+	// Instruction 0 is always InstFail, standing in for no transition
+	if pc == 0 || q.contains(pc) {
+		return t
+	}
+
+	j := len(q.dense)
+	q.dense = q.dense[:j+1]
+	d := &q.dense[j]
+	d.pc, d.t = pc, nil
+	q.sparse[pc] = uint32(j)
+
+	inst := &m.nfa.prog.Inst[pc]
+	switch inst.Op {
+	case syntax.InstAlt, syntax.InstAltMatch:
+		t = m.add(q, inst.Out, pos, cap, context, t)
+		t = m.add(q, inst.Arg, pos, cap, context, t)
+	case syntax.InstEmptyWidth:
+		if syntax.EmptyOp(inst.Arg)&^context == 0 {
+			t = m.add(q, inst.Out, pos, cap, context, t)
+		}
+	case syntax.InstNop:
+		t = m.add(q, inst.Out, pos, cap, context, t)
+	case syntax.InstCapture:
+		if int(inst.Arg) < len(cap) {
+			prev := cap[inst.Arg]
+			cap[inst.Arg] = pos
+			m.add(q, inst.Out, pos, cap, context, nil)
+			cap[inst.Arg] = prev
+		} else {
+			t = m.add(q, inst.Out, pos, cap, context, t)
+		}
+	case syntax.InstMatch, syntax.InstRune, syntax.InstRune1, syntax.InstRuneAny, syntax.InstRuneAnyNotNL:
+		if t == nil {
+			t = m.alloc(inst)
+		} else {
+			t.inst = inst
+		}
+		if &t.cap[0] != &cap[0] {
+			copy(t.cap, cap)
+		}
+		d.t = t
+		t = nil
+	}
+
+	return t
+}
+
+// step advances the threads of runq over r (at pos) into nextq. The first thread to reach a match cuts off those
+// with a lower priority.
+func (m *nfaMachine) step(runq, nextq *nfaQueue, pos, nextPos int, r rune, nextContext syntax.EmptyOp) {
+	for j := 0; j < len(runq.dense); j++ {
+		t := runq.dense[j].t
+		if t == nil {
+			continue
+		}
+
+		add := false
+		switch t.inst.Op {
+		case syntax.InstMatch:
+			t.cap[1] = pos
+			copy(m.matchcap, t.cap)
+			m.matched = true
+			for _, d := range runq.dense[j+1:] {
+				if d.t != nil {
+					m.pool = append(m.pool, d.t)
+				}
+			}
+			runq.dense = runq.dense[:0]
+		case syntax.InstRune:
+			add = t.inst.MatchRune(r)
+		case syntax.InstRune1:
+			add = r == t.inst.Rune[0]
+		case syntax.InstRuneAny:
+			add = true
+		case syntax.InstRuneAnyNotNL:
+			add = r != '\n'
+		}
+
+		if add {
+			t = m.add(nextq, t.inst.Out, nextPos, t.cap, nextContext, t)
+		}
+		if t != nil {
+			m.pool = append(m.pool, t)
+		}
+	}
+	runq.dense = runq.dense[:0]
+}